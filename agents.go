@@ -0,0 +1,65 @@
+// agents.go
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+)
+
+// How long a failed display name lookup is remembered before retrying
+const agentNameFailureTTL = time.Minute
+
+type agentNameEntry struct {
+	name      string
+	expiresAt time.Time
+}
+
+// Caches agent display names by agent ID so responses can name the agent
+// without a lookup per request
+type agentNameCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]agentNameEntry
+}
+
+func newAgentNameCache(ttl time.Duration) *agentNameCache {
+	return &agentNameCache{ttl: ttl, entries: make(map[string]agentNameEntry)}
+}
+
+// Returns the agent's display name, fetching it when missing or expired.
+// Returns "" when the agent cannot be fetched; failures are cached briefly
+// so an unreachable API does not add latency to every request.
+func (c *agentNameCache) get(ctx context.Context, agentID string) string {
+	c.mu.Lock()
+	entry, ok := c.entries[agentID]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.name
+	}
+
+	agentPath, err := buildAgentPath(appConfig.ProjectID, appConfig.LocationID, agentID)
+	if err != nil {
+		log.Printf("Warning: could not fetch display name for agent %s: %v", agentID, err)
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	agent, err := agentsClient.GetAgent(ctx, &cxpb.GetAgentRequest{Name: agentPath})
+
+	entry = agentNameEntry{expiresAt: time.Now().Add(c.ttl)}
+	if err != nil {
+		log.Printf("Warning: could not fetch display name for agent %s: %v", agentID, err)
+		entry.expiresAt = time.Now().Add(agentNameFailureTTL)
+	} else {
+		entry.name = agent.GetDisplayName()
+	}
+
+	c.mu.Lock()
+	c.entries[agentID] = entry
+	c.mu.Unlock()
+	return entry.name
+}
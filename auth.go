@@ -0,0 +1,75 @@
+// auth.go
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Permission that lets a key retarget detectIntent with X-Override-Agent-ID
+const permAgentOverride = "agent_override"
+
+// AuthMiddleware only lets requests through that present the given key as
+// "Authorization: Bearer <key>". An empty key rejects every request, so an
+// unconfigured key never leaves a route open.
+func AuthMiddleware(apiKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !validBearerToken(r, apiKey) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSONError(w, r, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Compares the request's bearer token against the key in constant time
+func validBearerToken(r *http.Request, apiKey string) bool {
+	if apiKey == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(apiKey)) == 1
+}
+
+// Parses API_KEYS: "key:perm1,perm2" entries separated by ";"
+func parseAPIKeys(spec string) (map[string][]string, error) {
+	keys := map[string][]string{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, perms, _ := strings.Cut(entry, ":")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid entry %q: expected key:perm1,perm2", entry)
+		}
+		for _, perm := range strings.Split(perms, ",") {
+			if perm = strings.TrimSpace(perm); perm != "" {
+				keys[key] = append(keys[key], perm)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// Reports whether the request's bearer token is an API_KEYS key granted the
+// permission. Every key is compared so timing does not reveal which matched.
+func hasKeyPermission(r *http.Request, keys map[string][]string, perm string) bool {
+	allowed := false
+	for key, perms := range keys {
+		if validBearerToken(r, key) && slices.Contains(perms, perm) {
+			allowed = true
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	setTestConfig(t, config{})
+	handler := AuthMiddleware("secret-key")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"valid key", "Bearer secret-key", http.StatusOK},
+		{"wrong key", "Bearer other-key", http.StatusUnauthorized},
+		{"key prefix", "Bearer secret", http.StatusUnauthorized},
+		{"not a bearer token", "Basic secret-key", http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareWithoutKeyRejectsAll(t *testing.T) {
+	setTestConfig(t, config{})
+	handler := AuthMiddleware("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys(" qa-key : agent_override , other ; plain-key ;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"qa-key": {"agent_override", "other"}} // A key without permissions grants nothing
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+	if _, err := parseAPIKeys(":agent_override"); err == nil {
+		t.Error("entry without a key accepted")
+	}
+}
+
+func TestAgentOverrideRequiresPermission(t *testing.T) {
+	cfg := testDetectIntentConfig()
+	cfg.APIKeys = map[string][]string{"qa-key": {permAgentOverride}, "plain-key": nil}
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"key with permission", "Bearer qa-key", http.StatusOK},
+		{"key without permission", "Bearer plain-key", http.StatusForbidden},
+		{"unknown key", "Bearer nope", http.StatusForbidden},
+		{"no key", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/detectIntent", strings.NewReader(`{"message":"hi","sessionId":"s1"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Override-Agent-ID", "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			detectIntentHandler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequestsWithoutOverrideUnaffected(t *testing.T) {
+	cfg := testDetectIntentConfig()
+	cfg.APIKeys = map[string][]string{"qa-key": {permAgentOverride}}
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+
+	rec, response := postDetectIntent(t, `{"message":"hi","sessionId":"s1"}`)
+	if rec.Code != http.StatusOK || response.Text != "hello" {
+		t.Errorf("status = %d, text %q; want 200, %q", rec.Code, response.Text, "hello")
+	}
+}
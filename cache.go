@@ -0,0 +1,144 @@
+// cache.go
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache of detectIntent replies (RESPONSE_CACHE_ENABLED), nil when disabled
+var responseCache *detectIntentCache
+
+// Replies to repeated questions on the same session, for read-heavy demo
+// and FAQ deployments. Entries expire after the TTL; past maxEntries the
+// least recently used entry is evicted, since keys include free-text
+// messages.
+type detectIntentCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	order   *list.List               // Most recently used first
+	entries map[string]*list.Element // Values are *cachedReply
+}
+
+type cachedReply struct {
+	key      string
+	response DetectIntentResponse
+	headers  http.Header // Header hints set with the reply
+	expires  time.Time
+}
+
+func newDetectIntentCache(ttl time.Duration, maxEntries int) *detectIntentCache {
+	return &detectIntentCache{ttl: ttl, maxEntries: maxEntries, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// Removes expired entries every TTL until ctx is done, so sessions that
+// are never asked again do not stay in memory
+func (c *detectIntentCache) startSweep(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(c.ttl)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				c.mu.Lock()
+				for element := c.order.Front(); element != nil; {
+					next := element.Next()
+					if now.After(element.Value.(*cachedReply).expires) {
+						c.remove(element)
+					}
+					element = next
+				}
+				c.mu.Unlock()
+			}
+		}
+	}()
+}
+
+func (c *detectIntentCache) get(key string) (cachedReply, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return cachedReply{}, false
+	}
+	reply := element.Value.(*cachedReply)
+	if time.Now().After(reply.expires) {
+		c.remove(element)
+		return cachedReply{}, false
+	}
+	c.order.MoveToFront(element)
+	return *reply, true
+}
+
+func (c *detectIntentCache) put(key string, response DetectIntentResponse, headers http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reply := &cachedReply{key: key, response: response, headers: headers, expires: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = reply
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(reply)
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// Drops an entry; c.mu must be held
+func (c *detectIntentCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cachedReply).key)
+}
+
+// Returns the cache key for a call, or false when its reply depends on more
+// than the agent, session, language, message and fallbackText: events,
+// several inputs, query parameters (flags, channel) or the raw result
+func responseCacheKey(call *detectIntentCall) (string, bool) {
+	if call.multiInput || call.raw || call.queryParams != nil || len(call.inputs) != 1 || call.inputs[0].Event != "" {
+		return "", false
+	}
+	message := strings.Join(strings.Fields(strings.ToLower(call.inputs[0].Message)), " ")
+	sum := sha256.Sum256([]byte(strings.Join([]string{call.agentID, call.sessionID, call.langCode, message, call.fallbackText}, "\x00")))
+	return hex.EncodeToString(sum[:]), true
+}
+
+// Runs detectIntentCore through the response cache. Hits are marked with
+// X-Cache-Hit: true and report no billable units, as Dialogflow is not
+// called.
+func cachedDetectIntentCore(ctx context.Context, h http.Header, call *detectIntentCall) (DetectIntentResponse, error) {
+	key, cacheable := responseCacheKey(call)
+	if responseCache == nil || !cacheable {
+		return detectIntentCore(ctx, h, call)
+	}
+
+	if reply, hit := responseCache.get(key); hit {
+		for name, values := range reply.headers {
+			h[name] = values
+		}
+		h.Set("X-Cache-Hit", "true")
+		response := reply.response
+		response.BillableUnits = 0
+		return response, nil
+	}
+
+	hints := http.Header{}
+	response, err := detectIntentCore(ctx, hints, call)
+	for name, values := range hints {
+		h[name] = values
+	}
+	if err == nil {
+		responseCache.put(key, response, hints)
+	}
+	return response, err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+)
+
+func TestDetectIntentCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newDetectIntentCache(time.Minute, 2)
+	cache.put("a", DetectIntentResponse{Text: "a"}, nil)
+	cache.put("b", DetectIntentResponse{Text: "b"}, nil)
+	if _, hit := cache.get("a"); !hit {
+		t.Fatal("a missing before the cache is full")
+	}
+	cache.put("c", DetectIntentResponse{Text: "c"}, nil) // Evicts b, the least recently used
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, hit := cache.get(key); hit != want {
+			t.Errorf("get(%q) hit = %v, want %v", key, hit, want)
+		}
+	}
+}
+
+func TestDetectIntentCacheStaysBounded(t *testing.T) {
+	cache := newDetectIntentCache(time.Minute, 100)
+	for i := 0; i < 1000; i++ {
+		cache.put(fmt.Sprint(i), DetectIntentResponse{}, nil)
+	}
+	if n := len(cache.entries); n != 100 || cache.order.Len() != 100 {
+		t.Errorf("cache holds %d entries (%d in order), want 100", n, cache.order.Len())
+	}
+}
+
+func TestDetectIntentCacheExpires(t *testing.T) {
+	cache := newDetectIntentCache(time.Millisecond, 10)
+	cache.put("a", DetectIntentResponse{}, nil)
+	time.Sleep(5 * time.Millisecond)
+	if _, hit := cache.get("a"); hit {
+		t.Error("expired entry returned")
+	}
+	if n := len(cache.entries); n != 0 {
+		t.Errorf("cache holds %d entries after expiry, want 0", n)
+	}
+}
+
+// Counts the detect-intent calls that reach the mock
+type countingSessionsClient struct {
+	*mockSessionsClient
+	calls int
+}
+
+func (c *countingSessionsClient) DetectIntent(ctx context.Context, req *cxpb.DetectIntentRequest, opts ...gax.CallOption) (*cxpb.DetectIntentResponse, error) {
+	c.calls++
+	return c.mockSessionsClient.DetectIntent(ctx, req, opts...)
+}
+
+func TestDetectIntentResponseCache(t *testing.T) {
+	cfg := testDetectIntentConfig()
+	cfg.Debug = true
+	setTestConfig(t, cfg)
+	client := &countingSessionsClient{mockSessionsClient: &mockSessionsClient{fixture: mockFixture{
+		Replies: []mockReply{{Message: "empty"}},
+		Default: &mockReply{Texts: []string{"hello"}},
+	}}}
+	previous, previousCache := sessionsClient, responseCache
+	sessionsClient, responseCache = client, newDetectIntentCache(time.Minute, 100)
+	t.Cleanup(func() { sessionsClient, responseCache = previous, previousCache })
+
+	tests := []struct {
+		name     string
+		body     string
+		wantHit  bool
+		wantText string
+	}{
+		{"miss", `{"message":"hi","sessionId":"s1"}`, false, "hello"},
+		{"hit, case and spacing ignored", `{"message":"  HI ","sessionId":"s1"}`, true, "hello"},
+		{"miss on another session", `{"message":"hi","sessionId":"s2"}`, false, "hello"},
+		{"bypass with inputs", `{"inputs":[{"message":"hi"}],"sessionId":"s1"}`, false, "hello"},
+		{"bypass with an event", `{"inputs":[{"event":"welcome"}],"sessionId":"s1"}`, false, "hello"},
+		{"bypass with raw", `{"message":"hi","sessionId":"s1","raw":true}`, false, "hello"},
+		{"fallbackText miss", `{"message":"empty","sessionId":"s1","fallbackText":"Lo siento."}`, false, "Lo siento."},
+		{"fallbackText hit", `{"message":"empty","sessionId":"s1","fallbackText":"Lo siento."}`, true, "Lo siento."},
+		{"other fallbackText misses", `{"message":"empty","sessionId":"s1","fallbackText":"Sorry."}`, false, "Sorry."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := client.calls
+			rec, response := postDetectIntent(t, tt.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			if hit := rec.Header().Get("X-Cache-Hit") == "true"; hit != tt.wantHit {
+				t.Errorf("X-Cache-Hit = %q, want hit %v", rec.Header().Get("X-Cache-Hit"), tt.wantHit)
+			}
+			if called := client.calls > calls; called == tt.wantHit {
+				t.Errorf("Dialogflow called = %v on hit %v", called, tt.wantHit)
+			}
+			if response.Text != tt.wantText {
+				t.Errorf("text = %q, want %q", response.Text, tt.wantText)
+			}
+			if tt.wantHit && response.BillableUnits != 0 {
+				t.Errorf("billableUnits = %d on a hit, want 0", response.BillableUnits)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// cookies.go
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Cookie carrying the session ID for clients that do not track it
+// (AUTO_SESSION_COOKIE)
+const sessionCookieName = "picolo_session"
+
+// Longest cookie value accepted as a session ID; CX allows 36 characters
+const maxSessionCookieLength = 36
+
+// Fills in a missing sessionId from the picolo_session cookie, or with
+// AUTO_SESSION_ID starts a new session and sets the cookie on the response
+func applySessionCookie(w http.ResponseWriter, r *http.Request, req *DetectIntentRequest) {
+	if !appConfig.AutoSessionCookie || req.SessionID != "" {
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" && len(cookie.Value) <= maxSessionCookieLength {
+		req.SessionID = cookie.Value
+		return
+	}
+	if !appConfig.AutoSessionID {
+		return
+	}
+	req.SessionID = uuid.NewString()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    req.SessionID,
+		Path:     "/",
+		MaxAge:   appConfig.SessionTTLSeconds,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Sends a request without sessionId to handler and returns the session ID
+// it answered for
+func postWithoutSession(t *testing.T, handler http.HandlerFunc, cookie string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"message":"hi"}`))
+	req.Header.Set("Content-Type", contentTypeJSON)
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		return rec, ""
+	}
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), contentTypeNDJSON) {
+		lines := decodeStreamLines(t, rec.Body.String())
+		return rec, lines[len(lines)-1].SessionID
+	}
+	var response DetectIntentResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding reply %q: %v", rec.Body.String(), err)
+	}
+	return rec, response.SessionID
+}
+
+func TestSessionCookie(t *testing.T) {
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+	handlers := map[string]http.HandlerFunc{
+		"detectIntent":  detectIntentHandler,
+		"stream-ndjson": streamNDJSONHandler,
+	}
+
+	tests := []struct {
+		name          string
+		cookieEnabled bool
+		autoSession   bool
+		cookie        string
+		wantStatus    int
+		wantSession   string // "new" for a generated ID
+	}{
+		{"read from cookie", true, false, "from-cookie", http.StatusOK, "from-cookie"},
+		{"created when missing", true, true, "", http.StatusOK, "new"},
+		{"missing without AUTO_SESSION_ID", true, false, "", http.StatusBadRequest, ""},
+		{"ignored when disabled", false, true, "from-cookie", http.StatusBadRequest, ""},
+	}
+	for handlerName, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(handlerName+"/"+tt.name, func(t *testing.T) {
+				cfg := testDetectIntentConfig()
+				cfg.AutoSessionCookie = tt.cookieEnabled
+				cfg.AutoSessionID = tt.autoSession
+				setTestConfig(t, cfg)
+
+				rec, sessionID := postWithoutSession(t, handler, tt.cookie)
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+				}
+				setCookie := rec.Header().Get("Set-Cookie")
+				switch tt.wantSession {
+				case "":
+				case "new":
+					if sessionID == "" || !strings.Contains(setCookie, sessionCookieName+"="+sessionID) {
+						t.Errorf("session %q, Set-Cookie %q; want a new session set as the cookie", sessionID, setCookie)
+					}
+				default:
+					if sessionID != tt.wantSession || setCookie != "" {
+						t.Errorf("session %q, Set-Cookie %q; want %q and no new cookie", sessionID, setCookie, tt.wantSession)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// cors.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+// Per-route CORS overrides from CORS_ROUTES_CONFIG, keyed by path. Unset
+// fields fall back to the global CORS settings.
+type corsRouteConfig struct {
+	AllowedOrigins   []string `json:"allowedOrigins,omitempty"`
+	AllowedMethods   []string `json:"allowedMethods,omitempty"`
+	AllowedHeaders   []string `json:"allowedHeaders,omitempty"`
+	AllowCredentials *bool    `json:"allowCredentials,omitempty"`
+}
+
+// Browsers refuse credentialed responses allowing any origin, so that
+// combination is a configuration error
+func checkCORSCredentials(allowedOrigin string, allowCredentials bool) error {
+	if allowCredentials && allowedOrigin == "*" {
+		return errors.New("ALLOWED_ORIGIN cannot be \"*\" when CORS_ALLOW_CREDENTIALS is true; set an explicit origin")
+	}
+	return nil
+}
+
+// Parses CORS_ROUTES_CONFIG, e.g.
+// {"/api/health": {"allowedMethods": ["GET"], "allowedOrigins": ["*"]}}
+func parseCORSRoutes(spec string, allowCredentials bool) (map[string]corsRouteConfig, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var routes map[string]corsRouteConfig
+	if err := json.Unmarshal([]byte(spec), &routes); err != nil {
+		return nil, err
+	}
+	for path, route := range routes {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("route %q must start with /", path)
+		}
+		credentials := allowCredentials
+		if route.AllowCredentials != nil {
+			credentials = *route.AllowCredentials
+		}
+		for _, origin := range route.AllowedOrigins {
+			if origin == "*" && credentials {
+				return nil, fmt.Errorf("route %q: origin \"*\" cannot be combined with credentials", path)
+			}
+		}
+	}
+	return routes, nil
+}
+
+// Global CORS options built from ALLOWED_ORIGIN and related settings
+func defaultCORSOptions(allowedMethods []string) cors.Options {
+	return cors.Options{
+		AllowedOrigins:     []string{appConfig.AllowedOrigin},
+		AllowedMethods:     allowedMethods,
+		AllowedHeaders:     appConfig.AllowedHeaders,
+		AllowCredentials:   appConfig.CORSAllowCredentials,
+		OptionsPassthrough: false,
+		Debug:              os.Getenv("CORS_DEBUG") == "true",
+	}
+}
+
+// Applies the CORS policy of the request's route: the exact path, or the
+// longest configured prefix ending in "/", falling back to the global
+// policy for routes without an override
+func routeAwareCORSMiddleware(defaults cors.Options, routes map[string]corsRouteConfig) middleware {
+	global := cors.New(defaults)
+	policies := map[string]*cors.Cors{}
+	for path, route := range routes {
+		options := defaults
+		if route.AllowedOrigins != nil {
+			options.AllowedOrigins = route.AllowedOrigins
+		}
+		if route.AllowedMethods != nil {
+			options.AllowedMethods = route.AllowedMethods
+		}
+		if route.AllowedHeaders != nil {
+			options.AllowedHeaders = route.AllowedHeaders
+		}
+		if route.AllowCredentials != nil {
+			options.AllowCredentials = *route.AllowCredentials
+		}
+		policies[path] = cors.New(options)
+	}
+
+	return func(next http.Handler) http.Handler {
+		globalHandler := global.Handler(next)
+		handlers := map[string]http.Handler{}
+		for path, policy := range policies {
+			handlers[path] = policy.Handler(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler, ok := handlers[r.URL.Path]
+			if !ok {
+				handler = globalHandler
+				longest := 0
+				for path, routeHandler := range handlers {
+					if strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path) && len(path) > longest {
+						handler, longest = routeHandler, len(path)
+					}
+				}
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckCORSCredentials(t *testing.T) {
+	tests := []struct {
+		origin      string
+		credentials bool
+		wantErr     bool
+	}{
+		{"*", false, false},
+		{"https://app.example.com", true, false},
+		{"*", true, true},
+	}
+	for _, tt := range tests {
+		err := checkCORSCredentials(tt.origin, tt.credentials)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("checkCORSCredentials(%q, %v) error = %v, wantErr %v", tt.origin, tt.credentials, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCORSRoutesRejectsWildcardWithCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		credentials bool
+		wantErr     bool
+	}{
+		{"wildcard without credentials", `{"/api/health": {"allowedOrigins": ["*"]}}`, false, false},
+		{"wildcard with global credentials", `{"/api/health": {"allowedOrigins": ["*"]}}`, true, true},
+		{"wildcard with route credentials", `{"/api/health": {"allowedOrigins": ["*"], "allowCredentials": true}}`, false, true},
+		{"wildcard with credentials turned off", `{"/api/health": {"allowedOrigins": ["*"], "allowCredentials": false}}`, true, false},
+		{"relative path", `{"api/health": {}}`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCORSRoutes(tt.spec, tt.credentials)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("parseCORSRoutes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCORSAllowCredentialsHeader(t *testing.T) {
+	for _, credentials := range []bool{true, false} {
+		setTestConfig(t, config{AllowedOrigin: "https://app.example.com", CORSAllowCredentials: credentials})
+		handler := routeAwareCORSMiddleware(defaultCORSOptions([]string{http.MethodPost}), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/detectIntent", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		want := ""
+		if credentials {
+			want = "true"
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != want {
+			t.Errorf("credentials %v: Access-Control-Allow-Credentials = %q, want %q", credentials, got, want)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Errorf("credentials %v: Access-Control-Allow-Origin = %q", credentials, got)
+		}
+	}
+}
+
+func TestCORSPreflightAllowHeaders(t *testing.T) {
+	setTestConfig(t, config{AllowedOrigin: "https://app.example.com", AllowedHeaders: []string{"Content-Type", "Authorization", "X-Request-ID"}})
+	routes := map[string]corsRouteConfig{"/api/health": {AllowedHeaders: []string{"Content-Type"}}}
+	handler := routeAwareCORSMiddleware(defaultCORSOptions([]string{http.MethodPost, http.MethodGet}), routes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("preflight for %s reached the handler", r.URL.Path)
+	}))
+
+	// Browsers send Access-Control-Request-Headers lowercased and sorted
+	tests := []struct {
+		name           string
+		path           string
+		requestHeaders string
+		wantAllowed    bool
+	}{
+		{"configured headers", "/api/dialogflow/detectIntent", "authorization,content-type,x-request-id", true},
+		{"header not configured", "/api/dialogflow/detectIntent", "content-type,x-custom", false},
+		{"route override", "/api/health", "content-type", true},
+		{"header dropped by route override", "/api/health", "authorization", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", tt.requestHeaders)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			allowHeaders := rec.Header().Get("Access-Control-Allow-Headers")
+			if tt.wantAllowed && allowHeaders != tt.requestHeaders {
+				t.Errorf("Access-Control-Allow-Headers = %q, want %q", allowHeaders, tt.requestHeaders)
+			}
+			if !tt.wantAllowed && allowHeaders != "" {
+				t.Errorf("Access-Control-Allow-Headers = %q, want none for a disallowed header", allowHeaders)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// credentials.go
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// Scope requested by the Dialogflow client libraries
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// Periodically asks the default token source for a token so a failing
+// refresh (revoked or expired credentials, metadata server issues) shows up
+// in the logs and in /admin/metrics before users start getting 403s. Runs in
+// the background and never blocks serving. Skipped for key-file credentials,
+// which sign their own tokens and cannot expire this way.
+func startCredentialCheck(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "" {
+		log.Printf("Credential refresh check skipped: using key-file credentials")
+		return
+	}
+
+	creds, err := google.FindDefaultCredentials(ctx, cloudPlatformScope)
+	if err != nil {
+		log.Printf("Warning: credential refresh check disabled, could not load default credentials: %v", err)
+		return
+	}
+
+	log.Printf("Credential refresh check running every %s", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// The token source caches tokens, so this only reaches the
+			// token endpoint when a refresh is due
+			if _, err := creds.TokenSource.Token(); err != nil {
+				credentialRefreshFailuresTotal.Add(1)
+				credentialRefreshHealthy.Set(0)
+				log.Printf("Warning: credential refresh failed: %v", err)
+				continue
+			}
+			credentialRefreshHealthy.Set(1)
+		}
+	}()
+}
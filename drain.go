@@ -0,0 +1,65 @@
+// drain.go
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Agent IDs currently draining. New requests for a draining agent are
+// rejected with 503 while calls already in flight complete normally.
+var drainingAgents sync.Map // map[string]bool
+
+// Suggested client wait before retrying a request for a draining agent; a
+// rolling update usually moves traffic to a new instance within seconds
+const drainRetryAfterSeconds = 5
+
+func isDraining(agentID string) bool {
+	draining, _ := drainingAgents.Load(agentID)
+	return draining == true
+}
+
+// Sorted list of draining agent IDs, for health output
+func drainingAgentIDs() []string {
+	ids := []string{}
+	drainingAgents.Range(func(key, _ interface{}) bool {
+		ids = append(ids, key.(string))
+		return true
+	})
+	sort.Strings(ids)
+	return ids
+}
+
+type drainResponse struct {
+	AgentID  string `json:"agentId"`
+	Draining bool   `json:"draining"`
+}
+
+// Handles POST /admin/agentPool/drain?agentId=...
+func drainAgentHandler(w http.ResponseWriter, r *http.Request) {
+	setAgentDraining(w, r, true)
+}
+
+// Handles POST /admin/agentPool/undrain?agentId=...
+func undrainAgentHandler(w http.ResponseWriter, r *http.Request) {
+	setAgentDraining(w, r, false)
+}
+
+func setAgentDraining(w http.ResponseWriter, r *http.Request, draining bool) {
+	agentID := r.URL.Query().Get("agentId")
+	if agentID == "" {
+		writeError(w, r, http.StatusBadRequest, "Missing required query parameter: agentId")
+		return
+	}
+
+	if draining {
+		drainingAgents.Store(agentID, true)
+		log.Printf("Agent %s is draining: new requests will be rejected", agentID)
+	} else {
+		drainingAgents.Delete(agentID)
+		log.Printf("Agent %s is no longer draining", agentID)
+	}
+	writeResponse(w, r, http.StatusOK, drainResponse{AgentID: agentID, Draining: draining})
+}
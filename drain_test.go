@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDrainAndUndrainAgent(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+	t.Cleanup(func() { drainingAgents.Delete(testAgentID) })
+	body := `{"message":"hi","sessionId":"s1"}`
+
+	rec := httptest.NewRecorder()
+	drainAgentHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/agentPool/drain?agentId="+testAgentID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("drain status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	rec, _ = postDetectIntent(t, body)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status while draining = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "5" {
+		t.Errorf("Retry-After = %q, want %q", got, "5")
+	}
+
+	rec = httptest.NewRecorder()
+	undrainAgentHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/agentPool/undrain?agentId="+testAgentID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("undrain status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	rec, response := postDetectIntent(t, body)
+	if rec.Code != http.StatusOK || response.Text != "hello" {
+		t.Errorf("after undrain: status = %d, text %q; want 200, %q", rec.Code, response.Text, "hello")
+	}
+}
+
+func TestDrainRequiresAgentID(t *testing.T) {
+	setTestConfig(t, config{})
+	rec := httptest.NewRecorder()
+	drainAgentHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/agentPool/drain", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,38 @@
+// dryrun.go
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Text returned in place of the agent's reply for X-Dry-Run requests
+const dryRunText = "dry-run"
+
+// Reports whether the request asks for a dry run with X-Dry-Run: true
+func isDryRun(r *http.Request) bool {
+	return r.Header.Get("X-Dry-Run") == "true"
+}
+
+// Answers a validated call without calling Dialogflow CX, logging the
+// requests that would have been sent when DEBUG is on. Lets integration
+// tests check request construction without spending quota.
+func writeDryRun(w http.ResponseWriter, r *http.Request, call *detectIntentCall) {
+	if appConfig.Debug {
+		for _, input := range call.inputs {
+			request, err := protojson.Marshal(call.dialogflowRequest(input))
+			if err != nil {
+				log.Printf("Error marshaling dry-run request: %v", err)
+				continue
+			}
+			log.Printf("Dry run, not sent to Dialogflow CX: %s", request)
+		}
+	}
+	writeResponse(w, r, http.StatusOK, DetectIntentResponse{
+		Text:      dryRunText,
+		SessionID: call.sessionID,
+		DryRun:    true,
+	})
+}
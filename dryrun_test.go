@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDryRunSkipsDialogflow(t *testing.T) {
+	cfg := testDetectIntentConfig()
+	cfg.APIKey = "secret-key"
+	setTestConfig(t, cfg)
+	client := &countingSessionsClient{mockSessionsClient: &mockSessionsClient{fixture: mockFixture{Default: &mockReply{Texts: []string{"hello"}}}}}
+	previous := sessionsClient
+	sessionsClient = client
+	t.Cleanup(func() { sessionsClient = previous })
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"with API key", "Bearer secret-key", http.StatusOK},
+		{"without API key", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/detectIntent", strings.NewReader(`{"message":"hi","sessionId":"s1"}`))
+			req.Header.Set("Content-Type", contentTypeJSON)
+			req.Header.Set("X-Dry-Run", "true")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			detectIntentHandler(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want == http.StatusOK {
+				var response DetectIntentResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || !response.DryRun || response.SessionID != "s1" {
+					t.Errorf("reply = %+v, %v; want a dry run for session s1", response, err)
+				}
+			}
+		})
+	}
+	if client.calls != 0 {
+		t.Errorf("Dialogflow CX was called %d times during dry runs", client.calls)
+	}
+}
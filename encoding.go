@@ -0,0 +1,180 @@
+// encoding.go
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	contentTypeJSON        = "application/json"
+	contentTypeCBOR        = "application/cbor"
+	contentTypeText        = "text/plain"
+	contentTypeEventStream = "text/event-stream"
+	contentTypeNDJSON      = "application/x-ndjson"
+)
+
+// Reports whether the request body is sent with the given media type
+func hasContentType(r *http.Request, mediaType string) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return false
+	}
+	parsed, _, err := mime.ParseMediaType(contentType)
+	return err == nil && parsed == mediaType
+}
+
+// Reports whether the Accept header explicitly lists the given media type
+func acceptsMediaType(r *http.Request, mediaType string) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		parsed, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && parsed == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// Decodes the raw request body as CBOR when Content-Type is
+// application/cbor, otherwise as JSON
+func decodeRequestBody(r *http.Request, body []byte, v interface{}) error {
+	if hasContentType(r, contentTypeCBOR) {
+		return cbor.Unmarshal(body, v)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// Checks the raw body against a hex-encoded SHA-256 digest sent by the client
+func validBodyChecksum(body []byte, checksum string) bool {
+	sum := sha256.Sum256(body)
+	expected := hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(checksum))) == 1
+}
+
+// Encodes the response as CBOR when the client accepts application/cbor,
+// otherwise as JSON. With RESPONSE_ENVELOPE the body is wrapped first.
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	if appConfig.ResponseEnvelope {
+		v = envelope{Data: v, Meta: newResponseMeta(r)}
+	}
+
+	if acceptsMediaType(r, contentTypeCBOR) {
+		body, err := cbor.Marshal(v)
+		if err != nil {
+			log.Printf("Error encoding CBOR response: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to encode response")
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeCBOR)
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	if err := newJSONEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// Writes a UTF-8 plain text body
+func writePlainText(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, text)
+}
+
+// Returns a JSON encoder that leaves <, > and & as-is so agent replies come
+// through unmangled, indenting output when PRETTY_JSON is set
+func newJSONEncoder(w io.Writer) *json.Encoder {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if appConfig.PrettyJSON {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder
+}
+
+// Response wrapper used when RESPONSE_ENVELOPE is enabled
+type envelope struct {
+	Data interface{}   `json:"data"`
+	Meta *responseMeta `json:"meta"`
+}
+
+type responseMeta struct {
+	RequestID string `json:"requestId"`
+	Timestamp string `json:"timestamp"`
+}
+
+func newResponseMeta(r *http.Request) *responseMeta {
+	return &responseMeta{
+		RequestID: requestIDFromContext(r.Context()),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// Error body returned by the API. Meta is only set with RESPONSE_ENVELOPE.
+type errorResponse struct {
+	Error    string            `json:"error"`
+	Category string            `json:"category"` // One of the error categories in errors.go
+	Details  []json.RawMessage `json:"details,omitempty"`
+	Meta     *responseMeta     `json:"meta,omitempty"`
+
+	// Set for 429/503/504 so clients can back off from the body alone
+	Retryable         bool `json:"retryable,omitempty"`
+	RetryAfterSeconds int  `json:"retryAfterSeconds,omitempty"`
+}
+
+// Writes a JSON error body with the given status
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	body := errorResponse{Error: message, Category: errorCategory(status)}
+	if appConfig.ResponseEnvelope {
+		body.Meta = newResponseMeta(r)
+	}
+	w.Header().Set(errorCategoryHeader, body.Category)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	if err := newJSONEncoder(w).Encode(body); err != nil {
+		log.Printf("Error encoding error response: %v", err)
+	}
+}
+
+// Writes a JSON error the client should retry (429, 503 or 504), with the
+// suggested delay both in the Retry-After header and in the body
+func writeRetryableError(w http.ResponseWriter, r *http.Request, status int, message string, retryAfterSeconds int) {
+	body := errorResponse{Error: message, Category: errorCategory(status), Retryable: true, RetryAfterSeconds: retryAfterSeconds}
+	if appConfig.ResponseEnvelope {
+		body.Meta = newResponseMeta(r)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+	w.Header().Set(errorCategoryHeader, body.Category)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	if err := newJSONEncoder(w).Encode(body); err != nil {
+		log.Printf("Error encoding error response: %v", err)
+	}
+}
+
+// Writes an error as plain text, or as a JSON envelope when
+// RESPONSE_ENVELOPE is enabled so every response has the same shape. Either
+// way the category is sent in X-Error-Category.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if appConfig.ResponseEnvelope {
+		writeJSONError(w, r, status, message)
+		return
+	}
+	w.Header().Set(errorCategoryHeader, errorCategory(status))
+	http.Error(w, message, status)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestPlainTextNegotiation(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"Hello & welcome"}}})
+
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+		wantBody        string // exact body for plain text, substring for JSON
+	}{
+		{"plain text", "text/plain", "text/plain; charset=utf-8", "Hello & welcome"},
+		{"plain text with parameters", "text/plain; charset=utf-8", "text/plain; charset=utf-8", "Hello & welcome"},
+		{"JSON preferred when both listed", "text/plain, application/json", contentTypeJSON, `"text":"Hello & welcome"`},
+		{"no Accept", "", contentTypeJSON, `"text":"Hello & welcome"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/detectIntent", strings.NewReader(`{"message":"hi","sessionId":"s1"}`))
+			req.Header.Set("Content-Type", contentTypeJSON)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			detectIntentHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			body := rec.Body.String()
+			if tt.wantContentType == contentTypeJSON {
+				if !strings.Contains(body, tt.wantBody) {
+					t.Errorf("body = %s, want it to contain %s", body, tt.wantBody)
+				}
+			} else if body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNewJSONEncoderLeavesHTMLCharacters(t *testing.T) {
+	for _, pretty := range []bool{false, true} {
+		setTestConfig(t, config{PrettyJSON: pretty})
+		var out strings.Builder
+		if err := newJSONEncoder(&out).Encode(map[string]string{"text": "<b>Fish & chips</b>"}); err != nil {
+			t.Fatal(err)
+		}
+		got := out.String()
+		for _, escaped := range []string{`\u003c`, `\u003e`, `\u0026`} {
+			if strings.Contains(got, escaped) {
+				t.Errorf("pretty %v: output %s contains %s", pretty, got, escaped)
+			}
+		}
+		if !strings.Contains(got, "<b>Fish & chips</b>") {
+			t.Errorf("pretty %v: output %s does not contain the text as-is", pretty, got)
+		}
+	}
+}
+
+func TestResponseEnvelopeShapes(t *testing.T) {
+	tests := []struct {
+		name     string
+		envelope bool
+		write    func(w http.ResponseWriter, r *http.Request)
+		wantKeys []string
+	}{
+		{"plain success", false, func(w http.ResponseWriter, r *http.Request) {
+			writeResponse(w, r, http.StatusOK, map[string]string{"text": "hello"})
+		}, []string{"text"}},
+		{"enveloped success", true, func(w http.ResponseWriter, r *http.Request) {
+			writeResponse(w, r, http.StatusOK, map[string]string{"text": "hello"})
+		}, []string{"data", "meta"}},
+		{"plain error", false, func(w http.ResponseWriter, r *http.Request) {
+			writeJSONError(w, r, http.StatusBadRequest, "bad")
+		}, []string{"category", "error"}},
+		{"enveloped error", true, func(w http.ResponseWriter, r *http.Request) {
+			writeJSONError(w, r, http.StatusBadRequest, "bad")
+		}, []string{"category", "error", "meta"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, config{ResponseEnvelope: tt.envelope})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Request-ID", "req-123")
+			rec := httptest.NewRecorder()
+			requestIDMiddleware(http.HandlerFunc(tt.write)).ServeHTTP(rec, req)
+
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+			}
+			keys := make([]string, 0, len(body))
+			for key := range body {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("top-level keys = %v, want %v", keys, tt.wantKeys)
+			}
+			if !tt.envelope {
+				return
+			}
+			var meta responseMeta
+			if err := json.Unmarshal(body["meta"], &meta); err != nil {
+				t.Fatalf("decoding meta %s: %v", body["meta"], err)
+			}
+			if meta.RequestID != "req-123" {
+				t.Errorf("meta.requestId = %q, want the X-Request-ID", meta.RequestID)
+			}
+			if _, err := time.Parse(time.RFC3339, meta.Timestamp); err != nil {
+				t.Errorf("meta.timestamp %q is not RFC 3339: %v", meta.Timestamp, err)
+			}
+		})
+	}
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+
+	body, err := cbor.Marshal(DetectIntentRequest{Message: "hi", SessionID: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/detectIntent", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentTypeCBOR)
+	req.Header.Set("Accept", contentTypeCBOR)
+	rec := httptest.NewRecorder()
+	detectIntentHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != contentTypeCBOR {
+		t.Errorf("Content-Type = %q, want %q", got, contentTypeCBOR)
+	}
+	var response DetectIntentResponse
+	if err := cbor.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding CBOR reply: %v", err)
+	}
+	if response.Text != "hello" || response.SessionID != "s1" {
+		t.Errorf("reply = %+v, want text %q for session s1", response, "hello")
+	}
+}
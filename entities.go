@@ -0,0 +1,84 @@
+// entities.go
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+)
+
+// How long an intent's parameter types are cached
+const intentParamTypesTTL = 10 * time.Minute
+
+// A matched parameter value paired with the entity type declared for it in
+// the matched intent, e.g. "projects/-/locations/-/agents/-/entityTypes/sys.date"
+type Entity struct {
+	Name       string      `json:"name"`
+	Value      interface{} `json:"value"`
+	EntityType string      `json:"entityType"`
+}
+
+type intentParamTypesEntry struct {
+	types     map[string]string
+	expiresAt time.Time
+}
+
+// Caches parameter ID → entity type per intent resource name
+type intentParamTypesCache struct {
+	mu      sync.Mutex
+	entries map[string]intentParamTypesEntry
+}
+
+var intentParamTypes = &intentParamTypesCache{entries: make(map[string]intentParamTypesEntry)}
+
+// Returns the intent's parameter types, or nil when they cannot be fetched
+func (c *intentParamTypesCache) get(ctx context.Context, intentName string) map[string]string {
+	c.mu.Lock()
+	entry, ok := c.entries[intentName]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.types
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	intent, err := intentsClient.GetIntent(ctx, &cxpb.GetIntentRequest{Name: intentName})
+	if err != nil {
+		log.Printf("Warning: could not fetch parameters of intent %s: %v", intentName, err)
+		return nil
+	}
+
+	types := make(map[string]string, len(intent.GetParameters()))
+	for _, parameter := range intent.GetParameters() {
+		types[parameter.GetId()] = parameter.GetEntityType()
+	}
+	c.mu.Lock()
+	c.entries[intentName] = intentParamTypesEntry{types: types, expiresAt: time.Now().Add(intentParamTypesTTL)}
+	c.mu.Unlock()
+	return types
+}
+
+// Pairs the turn's parameter values with the entity types declared by the
+// matched intent. Parameters the intent does not declare (e.g. page form
+// parameters) are left out, as their type is unknown.
+func extractEntities(ctx context.Context, queryResult *cxpb.QueryResult) []Entity {
+	intentName := queryResult.GetMatch().GetIntent().GetName()
+	parameters := queryResult.GetParameters().AsMap()
+	if intentName == "" || len(parameters) == 0 {
+		return nil
+	}
+	types := intentParamTypes.get(ctx, intentName)
+
+	var entities []Entity
+	for name, value := range parameters {
+		if entityType, ok := types[name]; ok {
+			entities = append(entities, Entity{Name: name, Value: value, EntityType: entityType})
+		}
+	}
+	sort.Slice(entities, func(i, j int) bool { return entities[i].Name < entities[j].Name })
+	return entities
+}
@@ -0,0 +1,115 @@
+// envfile.go
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+)
+
+// Loads ENV_FILE, or .env when it exists, into the environment. Variables
+// already set take precedence over the file.
+func loadEnvFile() {
+	path, explicit := os.LookupEnv("ENV_FILE")
+	if !explicit {
+		path = ".env"
+	}
+	if path == "" {
+		return
+	}
+
+	entries, err := parseEnvFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error: ENV_FILE %s: %v", path, err)
+	}
+
+	loaded := 0
+	for _, entry := range entries {
+		if _, exists := os.LookupEnv(entry[0]); exists {
+			continue
+		}
+		os.Setenv(entry[0], entry[1])
+		loaded++
+	}
+	log.Printf("Loaded %d of %d variables from %s", loaded, len(entries), path)
+}
+
+// Parses KEY=value lines in file order. Blank lines and # comments are
+// skipped, an "export " prefix is allowed, and values may be double quoted
+// (with \n, \" and \\ escapes) or single quoted (taken literally).
+func parseEnvFile(path string) ([][2]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries [][2]string
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNumber)
+		}
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		entries = append(entries, [2]string{key, value})
+	}
+	return entries, scanner.Err()
+}
+
+// Unquotes a value, or strips a trailing " #" comment from an unquoted one
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated single-quoted value")
+		}
+		return value[1 : end+1], nil
+	case '"':
+		var unquoted strings.Builder
+		for i := 1; i < len(value); i++ {
+			switch c := value[i]; {
+			case c == '"':
+				return unquoted.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					unquoted.WriteByte('\n')
+				case 't':
+					unquoted.WriteByte('\t')
+				default:
+					unquoted.WriteByte(value[i])
+				}
+			default:
+				unquoted.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated double-quoted value")
+	}
+	if comment := strings.Index(value, " #"); comment >= 0 {
+		value = strings.TrimSpace(value[:comment])
+	}
+	return value, nil
+}
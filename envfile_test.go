@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeEnvFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseEnvFile(t *testing.T) {
+	path := writeEnvFile(t, `# comment
+
+PORT=9090
+export DEBUG=true
+UNQUOTED=value # trailing comment
+DOUBLE="line one\nline \"two\""
+SINGLE='kept # as \n is'
+EMPTY=
+`)
+	got, err := parseEnvFile(path)
+	if err != nil {
+		t.Fatalf("parseEnvFile() error = %v", err)
+	}
+	want := [][2]string{
+		{"PORT", "9090"},
+		{"DEBUG", "true"},
+		{"UNQUOTED", "value"},
+		{"DOUBLE", "line one\nline \"two\""},
+		{"SINGLE", `kept # as \n is`},
+		{"EMPTY", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseEnvFile() = %q, want %q", got, want)
+	}
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"missing equals", "PORT 9090\n"},
+		{"space in key", "MY KEY=value\n"},
+		{"unterminated double quote", "KEY=\"value\n"},
+		{"unterminated single quote", "KEY='value\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseEnvFile(writeEnvFile(t, tt.content)); err == nil {
+				t.Errorf("parseEnvFile(%q) succeeded, want an error", tt.content)
+			}
+		})
+	}
+}
+
+func TestLoadEnvFileKeepsEnvironment(t *testing.T) {
+	t.Setenv("ENV_FILE", writeEnvFile(t, "PICOLO_TEST_SET=from-file\nPICOLO_TEST_UNSET=from-file\n"))
+	t.Setenv("PICOLO_TEST_SET", "from-env")
+	t.Setenv("PICOLO_TEST_UNSET", "")
+	os.Unsetenv("PICOLO_TEST_UNSET")
+
+	loadEnvFile()
+	if got := os.Getenv("PICOLO_TEST_SET"); got != "from-env" {
+		t.Errorf("PICOLO_TEST_SET = %q, want the environment's value", got)
+	}
+	if got := os.Getenv("PICOLO_TEST_UNSET"); got != "from-file" {
+		t.Errorf("PICOLO_TEST_UNSET = %q, want the file's value", got)
+	}
+}
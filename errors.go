@@ -0,0 +1,130 @@
+// errors.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails" // Registers detail types for protojson
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Header carrying the category of every error response, including
+// plain-text ones
+const errorCategoryHeader = "X-Error-Category"
+
+// Stable categories sent in every JSON error body, so clients can branch on
+// the kind of failure without parsing messages
+const (
+	categoryInvalidRequest     = "invalid_request"     // Fix the request before retrying
+	categoryRateLimited        = "rate_limited"        // Back off and retry
+	categoryBackendUnavailable = "backend_unavailable" // Dialogflow or the proxy is overloaded or down
+	categoryTimeout            = "timeout"             // The call ran out of time
+	categoryInternal           = "internal"            // Anything else
+)
+
+// Category of an error response from its HTTP status
+func errorCategory(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return categoryRateLimited
+	case status == http.StatusRequestTimeout, status == http.StatusGatewayTimeout:
+		return categoryTimeout
+	case status == http.StatusBadGateway, status == http.StatusServiceUnavailable:
+		return categoryBackendUnavailable
+	case status < 500:
+		return categoryInvalidRequest
+	default:
+		return categoryInternal
+	}
+}
+
+// Category of a failed Dialogflow CX call from its gRPC code
+func dialogflowErrorCategory(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return categoryTimeout
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded:
+		return categoryTimeout
+	case codes.ResourceExhausted:
+		return categoryRateLimited
+	case codes.Unavailable:
+		return categoryBackendUnavailable
+	case codes.InvalidArgument, codes.NotFound, codes.FailedPrecondition, codes.OutOfRange:
+		return categoryInvalidRequest
+	default:
+		return categoryInternal
+	}
+}
+
+// HTTP status of a failed Dialogflow CX call, consistent with its category
+func dialogflowErrorStatus(err error) int {
+	switch dialogflowErrorCategory(err) {
+	case categoryInvalidRequest:
+		if status.Code(err) == codes.NotFound {
+			return http.StatusNotFound
+		}
+		return http.StatusBadRequest
+	case categoryRateLimited:
+		return http.StatusTooManyRequests
+	case categoryBackendUnavailable:
+		return http.StatusServiceUnavailable
+	case categoryTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Writes a failed Dialogflow CX call as a JSON error with the status from
+// dialogflowErrorStatus, including any google.rpc.Status details (e.g.
+// BadRequest field violations) so clients can see what the backend
+// rejected. Rate limits, unavailability and timeouts are marked retryable.
+func writeDialogflowError(w http.ResponseWriter, r *http.Request, err error) {
+	code := dialogflowErrorStatus(err)
+	body := errorResponse{
+		Error:    fmt.Sprintf("Dialogflow CX API error: %v", err),
+		Category: dialogflowErrorCategory(err),
+		Details:  dialogflowErrorDetails(err),
+	}
+	if appConfig.ResponseEnvelope {
+		body.Meta = newResponseMeta(r)
+	}
+	switch code {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		body.Retryable, body.RetryAfterSeconds = true, 1
+		w.Header().Set("Retry-After", "1")
+	}
+	w.Header().Set(errorCategoryHeader, body.Category)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(code)
+	if err := newJSONEncoder(w).Encode(body); err != nil {
+		log.Printf("Error encoding error response: %v", err)
+	}
+}
+
+// Marshals the status details of a gRPC error with protojson, each tagged
+// with its "@type". Returns nil for non-gRPC errors or errors without details.
+func dialogflowErrorDetails(err error) []json.RawMessage {
+	s, ok := status.FromError(err)
+	if !ok {
+		return nil
+	}
+	var details []json.RawMessage
+	for _, detail := range s.Proto().GetDetails() {
+		encoded, err := protojson.Marshal(detail)
+		if err != nil {
+			log.Printf("Error encoding Dialogflow CX error detail %s: %v", detail.GetTypeUrl(), err)
+			continue
+		}
+		details = append(details, encoded)
+	}
+	return details
+}
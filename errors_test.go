@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWriteErrorSetsCategoryHeader(t *testing.T) {
+	for _, envelope := range []bool{false, true} {
+		setTestConfig(t, config{ResponseEnvelope: envelope})
+		rec := httptest.NewRecorder()
+		writeError(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusBadRequest, "bad")
+		if got := rec.Header().Get(errorCategoryHeader); got != categoryInvalidRequest {
+			t.Errorf("envelope=%v: %s = %q, want %q", envelope, errorCategoryHeader, got, categoryInvalidRequest)
+		}
+	}
+}
+
+func TestWriteDialogflowErrorStatus(t *testing.T) {
+	setTestConfig(t, config{})
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantRetry  bool
+	}{
+		{status.Error(codes.InvalidArgument, "bad"), http.StatusBadRequest, false},
+		{status.Error(codes.NotFound, "no agent"), http.StatusNotFound, false},
+		{status.Error(codes.ResourceExhausted, "quota"), http.StatusTooManyRequests, true},
+		{status.Error(codes.Unavailable, "down"), http.StatusServiceUnavailable, true},
+		{status.Error(codes.DeadlineExceeded, "slow"), http.StatusGatewayTimeout, true},
+		{status.Error(codes.Internal, "boom"), http.StatusInternalServerError, false},
+		{fmt.Errorf("turn 2: %w", status.Error(codes.ResourceExhausted, "quota")), http.StatusTooManyRequests, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeDialogflowError(rec, httptest.NewRequest(http.MethodGet, "/", nil), tt.err)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Category != errorCategory(tt.wantStatus) || rec.Header().Get(errorCategoryHeader) != body.Category {
+				t.Errorf("category = %q, header %q; want %q", body.Category, rec.Header().Get(errorCategoryHeader), errorCategory(tt.wantStatus))
+			}
+			if body.Retryable != tt.wantRetry || (rec.Header().Get("Retry-After") != "") != tt.wantRetry {
+				t.Errorf("retryable = %v, Retry-After %q; want retryable %v", body.Retryable, rec.Header().Get("Retry-After"), tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestWriteDialogflowErrorIncludesDetails(t *testing.T) {
+	setTestConfig(t, config{})
+	st, err := status.New(codes.InvalidArgument, "invalid query").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "query_input.text.text", Description: "Text is too long"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	writeDialogflowError(rec, httptest.NewRequest(http.MethodPost, "/", nil), st.Err())
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	var body struct {
+		Details []struct {
+			Type            string `json:"@type"`
+			FieldViolations []struct {
+				Field       string `json:"field"`
+				Description string `json:"description"`
+			} `json:"fieldViolations"`
+		} `json:"details"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+	}
+	if len(body.Details) != 1 || len(body.Details[0].FieldViolations) != 1 {
+		t.Fatalf("details = %+v, want one BadRequest with one violation", body.Details)
+	}
+	detail := body.Details[0]
+	if detail.Type != "type.googleapis.com/google.rpc.BadRequest" {
+		t.Errorf("@type = %q", detail.Type)
+	}
+	if v := detail.FieldViolations[0]; v.Field != "query_input.text.text" || v.Description != "Text is too long" {
+		t.Errorf("violation = %+v", v)
+	}
+}
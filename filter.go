@@ -0,0 +1,79 @@
+// filter.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const (
+	filterModeMask  = "mask"
+	filterModeBlock = "block"
+)
+
+// Masks or blocks listed words in reply text. Words are matched whole and
+// case-insensitively; a word is a run of Unicode letters and digits, so
+// "Darn" matches "darn!" but not "darned".
+type wordFilter struct {
+	words map[string]bool
+	mode  string
+}
+
+// Builds the filter from a comma-separated word list and an optional file
+// with one word per line (blank lines and lines starting with # ignored).
+// Returns nil when no words are configured.
+func newWordFilter(words []string, file, mode string) (*wordFilter, error) {
+	if mode != filterModeMask && mode != filterModeBlock {
+		return nil, fmt.Errorf("invalid mode %q: must be %q or %q", mode, filterModeMask, filterModeBlock)
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				words = append(words, line)
+			}
+		}
+	}
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	f := &wordFilter{words: make(map[string]bool, len(words)), mode: mode}
+	for _, word := range words {
+		f.words[strings.ToLower(word)] = true
+	}
+	return f, nil
+}
+
+// Returns the text with listed words masked, and whether any were found.
+// In block mode the caller should discard the text when found is true.
+func (f *wordFilter) apply(text string) (filtered string, found bool) {
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		if !isWordRune(runes[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		if f.words[strings.ToLower(string(runes[start:end]))] {
+			found = true
+			for i := start; i < end; i++ {
+				runes[i] = '*'
+			}
+		}
+		start = end
+	}
+	return string(runes), found
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+}
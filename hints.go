@@ -0,0 +1,84 @@
+// hints.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+)
+
+// Default mapping of response message types to response headers, in the
+// RESPONSE_HEADER_HINTS format
+const defaultHeaderHints = "liveAgentHandoff=X-Handoff:true,endInteraction=X-End-Interaction:true"
+
+// Response message types that can be mapped to headers, keyed by the
+// message field name used in the CX REST API
+var hintMessageTypes = map[string]func(*cxpb.ResponseMessage) bool{
+	"liveAgentHandoff":      func(m *cxpb.ResponseMessage) bool { return m.GetLiveAgentHandoff() != nil },
+	"endInteraction":        func(m *cxpb.ResponseMessage) bool { return m.GetEndInteraction() != nil },
+	"conversationSuccess":   func(m *cxpb.ResponseMessage) bool { return m.GetConversationSuccess() != nil },
+	"telephonyTransferCall": func(m *cxpb.ResponseMessage) bool { return m.GetTelephonyTransferCall() != nil },
+	"playAudio":             func(m *cxpb.ResponseMessage) bool { return m.GetPlayAudio() != nil },
+	"payload":               func(m *cxpb.ResponseMessage) bool { return m.GetPayload() != nil },
+}
+
+// A response header to set when a turn returns a given message type
+type headerHint struct {
+	MessageType string
+	Header      string
+	Value       string
+}
+
+// Parses "messageType=Header:value" entries separated by commas
+func parseHeaderHints(spec string) ([]headerHint, error) {
+	var hints []headerHint
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		messageType, header, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid header hint %q: expected messageType=Header:value", entry)
+		}
+		header, value, ok := strings.Cut(header, ":")
+		if !ok || header == "" {
+			return nil, fmt.Errorf("invalid header hint %q: expected messageType=Header:value", entry)
+		}
+		if _, known := hintMessageTypes[messageType]; !known {
+			return nil, fmt.Errorf("invalid header hint %q: unknown message type %q (supported: %s)",
+				entry, messageType, strings.Join(supportedHintTypes(), ", "))
+		}
+		hints = append(hints, headerHint{
+			MessageType: messageType,
+			Header:      http.CanonicalHeaderKey(strings.TrimSpace(header)),
+			Value:       strings.TrimSpace(value),
+		})
+	}
+	return hints, nil
+}
+
+func supportedHintTypes() []string {
+	types := make([]string, 0, len(hintMessageTypes))
+	for messageType := range hintMessageTypes {
+		types = append(types, messageType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Sets the header for every hint whose message type is among the messages
+func applyHeaderHints(h http.Header, messages []*cxpb.ResponseMessage, hints []headerHint) {
+	for _, hint := range hints {
+		matches := hintMessageTypes[hint.MessageType]
+		for _, message := range messages {
+			if matches(message) {
+				h.Set(hint.Header, hint.Value)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// intents.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	cx "cloud.google.com/go/dialogflow/cx/apiv3"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/iterator"
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+)
+
+// Full intent definition returned by the intent detail endpoint
+type IntentDetail struct {
+	Name            string            `json:"name"`
+	DisplayName     string            `json:"displayName"`
+	Description     string            `json:"description,omitempty"`
+	Priority        int32             `json:"priority"`
+	IsFallback      bool              `json:"isFallback"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	TrainingPhrases []TrainingPhrase  `json:"trainingPhrases"`
+	Parameters      []IntentParameter `json:"parameters"`
+}
+
+// A training phrase, with Text being the concatenation of its parts
+type TrainingPhrase struct {
+	ID          string               `json:"id"`
+	Text        string               `json:"text"`
+	Parts       []TrainingPhrasePart `json:"parts"`
+	RepeatCount int32                `json:"repeatCount"`
+}
+
+// A span of a training phrase, annotated when it maps to a parameter
+type TrainingPhrasePart struct {
+	Text        string `json:"text"`
+	ParameterID string `json:"parameterId,omitempty"`
+}
+
+type IntentParameter struct {
+	ID         string `json:"id"`
+	EntityType string `json:"entityType"`
+	IsList     bool   `json:"isList"`
+	Redact     bool   `json:"redact"`
+}
+
+var errIntentNotFound = errors.New("intent not found")
+
+// The intent lookups the handlers make, satisfied by the real CX intents
+// client (through cxIntentsClient) and by test fakes
+type intentsAPI interface {
+	ListIntents(ctx context.Context, req *cxpb.ListIntentsRequest, opts ...gax.CallOption) intentIterator
+	GetIntent(ctx context.Context, req *cxpb.GetIntentRequest, opts ...gax.CallOption) (*cxpb.Intent, error)
+	Close() error
+}
+
+// Pages through ListIntents results; Next returns iterator.Done at the end
+type intentIterator interface {
+	Next() (*cxpb.Intent, error)
+}
+
+// Adapts the CX intents client, whose ListIntents returns a concrete
+// iterator type, to intentsAPI
+type cxIntentsClient struct {
+	*cx.IntentsClient
+}
+
+func (c cxIntentsClient) ListIntents(ctx context.Context, req *cxpb.ListIntentsRequest, opts ...gax.CallOption) intentIterator {
+	return c.IntentsClient.ListIntents(ctx, req, opts...)
+}
+
+// Handles GET /api/dialogflow/agents/{agentId}/intents/{displayName}: looks
+// the intent up by display name and returns its training phrases and
+// parameters. An optional languageCode query parameter selects the
+// language of the training phrases.
+func intentDetailHandler(w http.ResponseWriter, r *http.Request) {
+	agentID := r.PathValue("agentId")
+	displayName := r.PathValue("displayName")
+	agentPath, err := buildAgentPath(appConfig.ProjectID, appConfig.LocationID, agentID)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := dialogflowContext(w, r, agentID)
+	defer cancel()
+
+	intentName, err := findIntentName(ctx, agentPath, displayName)
+	if errors.Is(err, errIntentNotFound) {
+		writeError(w, r, http.StatusNotFound, fmt.Sprintf("No intent named %q in agent %s", displayName, agentID))
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing intents for %s: %v", agentPath, err)
+		writeDialogflowError(w, r, err)
+		return
+	}
+
+	intent, err := intentsClient.GetIntent(ctx, &cxpb.GetIntentRequest{
+		Name:         intentName,
+		LanguageCode: r.URL.Query().Get("languageCode"),
+	})
+	if err != nil {
+		log.Printf("Error getting intent %s: %v", intentName, err)
+		writeDialogflowError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, toIntentDetail(intent))
+}
+
+// Scans the agent's intents for an exact display name match and returns
+// the intent's resource name
+func findIntentName(ctx context.Context, agentPath, displayName string) (string, error) {
+	it := intentsClient.ListIntents(ctx, &cxpb.ListIntentsRequest{
+		Parent:     agentPath,
+		IntentView: cxpb.IntentView_INTENT_VIEW_PARTIAL, // Names only, no training phrases
+	})
+	for {
+		intent, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return "", errIntentNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+		if intent.GetDisplayName() == displayName {
+			return intent.GetName(), nil
+		}
+	}
+}
+
+func toIntentDetail(intent *cxpb.Intent) IntentDetail {
+	detail := IntentDetail{
+		Name:            intent.GetName(),
+		DisplayName:     intent.GetDisplayName(),
+		Description:     intent.GetDescription(),
+		Priority:        intent.GetPriority(),
+		IsFallback:      intent.GetIsFallback(),
+		Labels:          intent.GetLabels(),
+		TrainingPhrases: []TrainingPhrase{},
+		Parameters:      []IntentParameter{},
+	}
+	for _, phrase := range intent.GetTrainingPhrases() {
+		var text strings.Builder
+		parts := make([]TrainingPhrasePart, 0, len(phrase.GetParts()))
+		for _, part := range phrase.GetParts() {
+			text.WriteString(part.GetText())
+			parts = append(parts, TrainingPhrasePart{Text: part.GetText(), ParameterID: part.GetParameterId()})
+		}
+		detail.TrainingPhrases = append(detail.TrainingPhrases, TrainingPhrase{
+			ID:          phrase.GetId(),
+			Text:        text.String(),
+			Parts:       parts,
+			RepeatCount: phrase.GetRepeatCount(),
+		})
+	}
+	for _, parameter := range intent.GetParameters() {
+		detail.Parameters = append(detail.Parameters, IntentParameter{
+			ID:         parameter.GetId(),
+			EntityType: parameter.GetEntityType(),
+			IsList:     parameter.GetIsList(),
+			Redact:     parameter.GetRedact(),
+		})
+	}
+	return detail
+}
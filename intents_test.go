@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/iterator"
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+)
+
+// Serves a fixed set of intents in place of the CX intents client
+type fakeIntentsClient struct {
+	intents []*cxpb.Intent
+}
+
+type fakeIntentIterator struct {
+	intents []*cxpb.Intent
+}
+
+func (it *fakeIntentIterator) Next() (*cxpb.Intent, error) {
+	if len(it.intents) == 0 {
+		return nil, iterator.Done
+	}
+	intent := it.intents[0]
+	it.intents = it.intents[1:]
+	return intent, nil
+}
+
+func (c *fakeIntentsClient) ListIntents(ctx context.Context, req *cxpb.ListIntentsRequest, opts ...gax.CallOption) intentIterator {
+	return &fakeIntentIterator{intents: c.intents}
+}
+
+func (c *fakeIntentsClient) GetIntent(ctx context.Context, req *cxpb.GetIntentRequest, opts ...gax.CallOption) (*cxpb.Intent, error) {
+	for _, intent := range c.intents {
+		if intent.GetName() == req.GetName() {
+			return intent, nil
+		}
+	}
+	return nil, errIntentNotFound
+}
+
+func (c *fakeIntentsClient) Close() error { return nil }
+
+func setTestIntentsClient(t *testing.T, intents ...*cxpb.Intent) {
+	t.Helper()
+	previous := intentsClient
+	intentsClient = &fakeIntentsClient{intents: intents}
+	t.Cleanup(func() { intentsClient = previous })
+}
+
+func getIntentDetail(agentID, displayName string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/dialogflow/agents/"+agentID+"/intents/"+displayName, nil)
+	req.SetPathValue("agentId", agentID)
+	req.SetPathValue("displayName", displayName)
+	rec := httptest.NewRecorder()
+	intentDetailHandler(rec, req)
+	return rec
+}
+
+func TestIntentDetailHandler(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	agentPath := "projects/my-project/locations/global/agents/" + testAgentID
+	setTestIntentsClient(t,
+		&cxpb.Intent{Name: agentPath + "/intents/other", DisplayName: "other"},
+		&cxpb.Intent{
+			Name:        agentPath + "/intents/order",
+			DisplayName: "order.pizza",
+			TrainingPhrases: []*cxpb.Intent_TrainingPhrase{{
+				Id: "phrase-1",
+				Parts: []*cxpb.Intent_TrainingPhrase_Part{
+					{Text: "I want a "},
+					{Text: "large", ParameterId: "size"},
+					{Text: " pizza"},
+				},
+				RepeatCount: 1,
+			}},
+			Parameters: []*cxpb.Intent_Parameter{{Id: "size", EntityType: "projects/-/locations/-/agents/-/entityTypes/sys.any"}},
+		},
+	)
+
+	rec := getIntentDetail(testAgentID, "order.pizza")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var detail IntentDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if detail.Name != agentPath+"/intents/order" || len(detail.TrainingPhrases) != 1 || len(detail.Parameters) != 1 {
+		t.Fatalf("detail = %+v", detail)
+	}
+	if got := detail.TrainingPhrases[0].Text; got != "I want a large pizza" {
+		t.Errorf("training phrase text = %q, want %q", got, "I want a large pizza")
+	}
+	if got := detail.TrainingPhrases[0].Parts[1].ParameterID; got != "size" {
+		t.Errorf("annotated part parameter = %q, want %q", got, "size")
+	}
+}
+
+func TestIntentDetailHandlerErrors(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestIntentsClient(t, &cxpb.Intent{Name: "projects/my-project/locations/global/agents/" + testAgentID + "/intents/other", DisplayName: "other"})
+
+	tests := []struct {
+		name        string
+		agentID     string
+		displayName string
+		want        int
+	}{
+		{"unknown intent", testAgentID, "order.pizza", http.StatusNotFound},
+		{"invalid agent ID", "not-a-uuid", "other", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := getIntentDetail(tt.agentID, tt.displayName); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// line.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// LINE Messaging API reply endpoint (LINE_REPLY_URL overrides it, e.g. for
+// a local stub)
+const defaultLineReplyURL = "https://api.line.me/v2/bot/message/reply"
+
+// Webhook body sent by the LINE platform; only the fields used here
+type lineWebhook struct {
+	Events []lineEvent `json:"events"`
+}
+
+type lineEvent struct {
+	Type       string `json:"type"`
+	ReplyToken string `json:"replyToken"`
+	Source     struct {
+		UserID string `json:"userId"`
+	} `json:"source"`
+	Message struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+type lineReply struct {
+	ReplyToken string             `json:"replyToken"`
+	Messages   []lineReplyMessage `json:"messages"`
+}
+
+type lineReplyMessage struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Handles POST /webhook/line: verifies the LINE signature, sends the first
+// event's text to the default agent with the LINE user as the session, and
+// answers through the LINE Reply API
+func lineWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading LINE webhook body: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !validLineSignature(body, r.Header.Get("X-Line-Signature"), appConfig.LineChannelSecret) {
+		log.Printf("Rejected LINE webhook: invalid signature")
+		writeJSONError(w, r, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+	var webhook lineWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		log.Printf("Error parsing LINE webhook: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Invalid webhook body")
+		return
+	}
+
+	// LINE's webhook verification sends no events; other event types
+	// (follow, sticker messages, ...) have no text to send
+	if len(webhook.Events) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	event := webhook.Events[0]
+	if event.Type != "message" || event.Message.Type != "text" || event.ReplyToken == "" {
+		log.Printf("Ignoring LINE event: type=%s message type=%s", event.Type, event.Message.Type)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	call, err := prepareDetectIntent(DetectIntentRequest{
+		Message:   event.Message.Text,
+		SessionID: event.Source.UserID,
+	})
+	if err != nil {
+		log.Printf("Validation Error: %v", err)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if isDraining(call.agentID) {
+		log.Printf("Rejected LINE event for draining agent %s", call.agentID)
+		writeRetryableError(w, r, http.StatusServiceUnavailable, "Agent is draining, retry shortly", drainRetryAfterSeconds)
+		return
+	}
+
+	ctx, cancel := dialogflowContext(w, r, call.agentID)
+	defer cancel()
+
+	response, err := detectIntentCore(ctx, http.Header{}, call)
+	if err != nil {
+		writeDetectIntentError(w, r, err)
+		return
+	}
+	if response.Text == "" {
+		log.Printf("No text to reply to LINE user %s", event.Source.UserID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := sendLineReply(ctx, event.ReplyToken, response.displayText()); err != nil {
+		log.Printf("Error replying to LINE: %v", err)
+		writeError(w, r, http.StatusBadGateway, "LINE reply failed")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Checks X-Line-Signature: the base64 HMAC-SHA256 of the raw body keyed with
+// the channel secret
+func validLineSignature(body []byte, signature, secret string) bool {
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || secret == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Sends a single text message with the LINE Reply API
+func sendLineReply(ctx context.Context, replyToken, text string) error {
+	payload, err := json.Marshal(lineReply{
+		ReplyToken: replyToken,
+		Messages:   []lineReplyMessage{{Type: "text", Text: text}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, appConfig.LineReplyURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	req.Header.Set("Authorization", "Bearer "+appConfig.LineChannelAccessToken)
+
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("LINE Reply API returned %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testLineSecret = "line-secret"
+
+func lineSignature(body string) string {
+	mac := hmac.New(sha256.New, []byte(testLineSecret))
+	mac.Write([]byte(body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestLineWebhookSignature(t *testing.T) {
+	var replies []lineReply
+	replyAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer line-token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		var reply lineReply
+		if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+			t.Errorf("decoding reply: %v", err)
+		}
+		replies = append(replies, reply)
+	}))
+	defer replyAPI.Close()
+
+	cfg := testDetectIntentConfig()
+	cfg.LineChannelSecret = testLineSecret
+	cfg.LineChannelAccessToken = "line-token"
+	cfg.LineReplyURL = replyAPI.URL
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello from the agent"}}})
+
+	body := `{"events":[{"type":"message","replyToken":"token-1","source":{"userId":"U123"},"message":{"type":"text","text":"hi"}}]}`
+	tampered := strings.Replace(body, `"hi"`, `"bye"`, 1)
+
+	tests := []struct {
+		name        string
+		body        string
+		signature   string
+		want        int
+		wantReplies int
+	}{
+		{"valid", body, lineSignature(body), http.StatusOK, 1},
+		{"tampered body", tampered, lineSignature(body), http.StatusUnauthorized, 0},
+		{"wrong secret", body, base64.StdEncoding.EncodeToString([]byte("not the mac")), http.StatusUnauthorized, 0},
+		{"missing", body, "", http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies = nil
+			req := httptest.NewRequest(http.MethodPost, "/webhook/line", strings.NewReader(tt.body))
+			if tt.signature != "" {
+				req.Header.Set("X-Line-Signature", tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			lineWebhookHandler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.want, rec.Body.String())
+			}
+			if len(replies) != tt.wantReplies {
+				t.Fatalf("sent %d replies, want %d", len(replies), tt.wantReplies)
+			}
+			if tt.wantReplies > 0 {
+				reply := replies[0]
+				if reply.ReplyToken != "token-1" || len(reply.Messages) != 1 || reply.Messages[0].Text != "hello from the agent" {
+					t.Errorf("reply = %+v", reply)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// loadshed.go
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Retry-After sent with shed requests
+	loadShedRetryAfterSeconds = 5
+
+	// Fewer samples than this in the window never trigger shedding, so a
+	// single slow request on an idle instance does not
+	loadShedMinSamples = 20
+
+	// Oldest samples are dropped beyond this many, bounding memory and the
+	// cost of each p95 computation
+	loadShedMaxSamples = 10000
+)
+
+// Load shedder (LOAD_SHED_P95_MS), nil when disabled
+var loadShed *loadShedder
+
+// Rejects a fraction of new requests while the p95 latency of recent
+// Dialogflow CX calls is above the threshold (LOAD_SHED_P95_MS), giving a
+// slow backend room to recover instead of queueing ever more work on it
+type loadShedder struct {
+	threshold time.Duration
+	fraction  float64
+	window    time.Duration
+
+	mu       sync.Mutex
+	samples  []latencySample // Oldest first
+	shedding atomic.Bool
+}
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+func newLoadShedder(threshold time.Duration, fraction float64, window time.Duration) *loadShedder {
+	return &loadShedder{threshold: threshold, fraction: fraction, window: window}
+}
+
+// Re-evaluates the rolling window every second until ctx is done
+func (s *loadShedder) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.update(now)
+			}
+		}
+	}()
+}
+
+func (s *loadShedder) record(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) >= loadShedMaxSamples {
+		s.samples = s.samples[1:]
+	}
+	s.samples = append(s.samples, latencySample{at: time.Now(), latency: latency})
+}
+
+// Drops samples older than the window and switches shedding on or off
+// based on the p95 of the rest
+func (s *loadShedder) update(now time.Time) {
+	s.mu.Lock()
+	cutoff := now.Add(-s.window)
+	expired := 0
+	for expired < len(s.samples) && s.samples[expired].at.Before(cutoff) {
+		expired++
+	}
+	s.samples = s.samples[expired:]
+	latencies := make([]time.Duration, len(s.samples))
+	for i, sample := range s.samples {
+		latencies[i] = sample.latency
+	}
+	s.mu.Unlock()
+
+	var p95 time.Duration
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		p95 = latencies[len(latencies)*95/100]
+	}
+	loadShedLatencyP95Ms.Set(p95.Milliseconds())
+
+	shedding := len(latencies) >= loadShedMinSamples && p95 > s.threshold
+	if s.shedding.Swap(shedding) != shedding {
+		if shedding {
+			log.Printf("Warning: p95 latency %v is above %v, shedding %g of new requests", p95, s.threshold, s.fraction)
+		} else {
+			log.Printf("p95 latency %v is back under %v, no longer shedding requests", p95, s.threshold)
+		}
+	}
+	if shedding {
+		loadShedActive.Set(1)
+	} else {
+		loadShedActive.Set(0)
+	}
+}
+
+// Records the latency of one unary detect-intent call. Only single calls are
+// sampled: a stream lasts as long as the agent keeps sending and a replay
+// chains up to 20 calls, so their request time says nothing about how fast
+// Dialogflow CX answers.
+func recordDialogflowLatency(latency time.Duration) {
+	if loadShed != nil {
+		loadShed.record(latency)
+	}
+}
+
+// Sheds requests while the shedder is active
+func (s *loadShedder) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.shedding.Load() && rand.Float64() < s.fraction {
+			loadShedRequestsTotal.Add(1)
+			writeRetryableError(w, r, http.StatusServiceUnavailable, "Server is overloaded, retry shortly", loadShedRetryAfterSeconds)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadShedSamplesDialogflowCallsOnly(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+	previous := loadShed
+	loadShed = newLoadShedder(time.Second, 1, time.Minute)
+	t.Cleanup(func() { loadShed = previous })
+
+	// A long-lived request through the middleware is not a sample
+	slow := loadShed.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	slow.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if n := len(loadShed.samples); n != 0 {
+		t.Fatalf("middleware recorded %d samples, want 0", n)
+	}
+
+	// Each input of a detectIntent request is one sample
+	if rec, _ := postDetectIntent(t, `{"inputs":[{"message":"a"},{"message":"b"}],"sessionId":"s1"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	if n := len(loadShed.samples); n != 2 {
+		t.Errorf("recorded %d samples, want 2", n)
+	}
+}
@@ -4,44 +4,173 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	cx "cloud.google.com/go/dialogflow/cx/apiv3"
-	"github.com/rs/cors"
-	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
 	"google.golang.org/api/option"
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 // Configuration struct to hold environment variables
 type config struct {
-	ProjectID     string
-	LocationID    string
-	AllowedOrigin string
-	Port          string
-	DefaultAgentID string
+	ProjectID                    string
+	LocationID                   string
+	AllowedOrigin                string
+	Port                         string
+	DefaultAgentID               string
+	AllowedHeaders               []string
+	PayloadKeyAllowlist          []string
+	PayloadKeyAllowlistRecursive bool
+	AllowGetDetect               bool
+	EnablePprof                  bool
+	PprofAPIKey                  string
+	SynthesizePayloadText        bool
+	CSPHeader                    string
+	HeaderHints                  []headerHint
+	MultilangPrefixFormat        string
+	ResponseEnvelope             bool
+	CredentialCheckInterval      time.Duration
+	IncludeAgentName             bool
+	AgentNameCacheTTL            time.Duration
+	NoAgentErrorMessage          string
+	PrettyJSON                   bool
+	APIKey                       string
+	IncludeEntities              bool
+	WebhookForwardURLs           []string
+	ResponseFilter               *wordFilter
+	ResponseFilterFallbackText   string
+	Debug                        bool
+	ServerReadTimeout            time.Duration
+	ServerWriteTimeout           time.Duration
+	DialogflowTimeout            time.Duration
+	AgentTimeouts                map[string]time.Duration
+	RegionEndpoints              map[string]string
+	CORSAllowCredentials         bool
+	GlobalDialogflowRPS          float64
+	MaxRequestBodyBytes          int64
+	DefaultEmptyResponseText     string
+	ResponseHeaders              http.Header
+	APIKeys                      map[string][]string
+	MockFixture                  string
+	QuotaLimitPerMinute          int64
+	SLOLatency                   time.Duration
+	LoadShedLatency              time.Duration
+	LoadShedFraction             float64
+	LoadShedWindow               time.Duration
+	PingAgentID                  string
+	PingSessionID                string
+	PingMinInterval              time.Duration
+	ResponseCacheEnabled         bool
+	ResponseCacheTTL             time.Duration
+	ResponseCacheMaxEntries      int
+	OutboundHMACKey              string
+	WebhookForwardKey            string
+	AutoSessionCookie            bool
+	AutoSessionID                bool
+	SessionTTLSeconds            int
+	MaxConcurrentDialogflowCalls int
+	LineChannelSecret            string
+	LineChannelAccessToken       string
+	LineReplyURL                 string
+	UTF8Mode                     string
+	StreamingResponseMinParts    int
+	CORSRoutes                   map[string]corsRouteConfig
+	TeamsAppID                   string
+	TeamsAppPassword             string
+	TeamsOpenIDURL               string
+	TeamsTokenURL                string
 }
 
 // Request struct matching the expected JSON body from the client
 type DetectIntentRequest struct {
-	Message      string `json:"message"`
-	AgentID      string `json:"agentId"`     
-	SessionID    string `json:"sessionId"`   
-	LanguageCode string `json:"languageCode"` 
+	Message      string                 `json:"message"`
+	AgentID      string                 `json:"agentId"`
+	SessionID    string                 `json:"sessionId"`
+	LanguageCode string                 `json:"languageCode"`
+	Inputs       []QueryInput           `json:"inputs,omitempty"`
+	Flags        map[string]interface{} `json:"flags,omitempty"`
+	FallbackText string                 `json:"fallbackText,omitempty"`
+	Stream       bool                   `json:"stream,omitempty"`
+	Raw          bool                   `json:"raw,omitempty"`
+	Channel      string                 `json:"channel,omitempty"`
+}
+
+// A single text or event input, sent in order when a request has several
+type QueryInput struct {
+	Message string `json:"message,omitempty"`
+	Event   string `json:"event,omitempty"`
 }
 
 // Response struct sent back to the client
 type DetectIntentResponse struct {
-	Text      string `json:"text"` 
-	SessionID string `json:"sessionId"`
+	Text           string          `json:"text"`
+	SessionID      string          `json:"sessionId"`
+	RichContent    []RichContent   `json:"richContent,omitempty"`
+	Responses      []TurnResponse  `json:"responses,omitempty"`
+	BillableUnits  int             `json:"billableUnits"`
+	AgentName      string          `json:"agentName,omitempty"`
+	Entities       []Entity        `json:"entities,omitempty"`
+	WasFallback    bool            `json:"wasFallback,omitempty"`
+	AudioURIs      []string        `json:"audioUris,omitempty"`
+	RawQueryResult json.RawMessage `json:"rawQueryResult,omitempty"`
+	WebhookStatus  string          `json:"webhookStatus,omitempty"`
+	WebhookMessage string          `json:"webhookMessage,omitempty"`
+	SpeakText      string          `json:"speakText,omitempty"`
+	SSML           string          `json:"ssml,omitempty"`
+	DryRun         bool            `json:"dryRun,omitempty"`
+
+	parts []streamLine // Last turn's reply as message lines, for NDJSON parts
 }
 
+// Reply to one input of a multi-input request
+type TurnResponse struct {
+	Text           string          `json:"text"`
+	RichContent    []RichContent   `json:"richContent,omitempty"`
+	Entities       []Entity        `json:"entities,omitempty"`
+	WasFallback    bool            `json:"wasFallback,omitempty"`
+	AudioURIs      []string        `json:"audioUris,omitempty"`
+	RawQueryResult json.RawMessage `json:"rawQueryResult,omitempty"`
+	WebhookStatus  string          `json:"webhookStatus,omitempty"`
+	WebhookMessage string          `json:"webhookMessage,omitempty"`
+	SpeakText      string          `json:"speakText,omitempty"`
+	SSML           string          `json:"ssml,omitempty"`
+}
+
+// Upper bound on inputs in a single request
+const maxQueryInputs = 10
+
+// Feature flags are forwarded to fulfillment as this session parameter
+const (
+	flagsParameterKey = "picolo_flags"
+	maxFlags          = 20
+	maxFlagKeyLength  = 64
+	maxFlagsBytes     = 2048
+)
+
+// Upper bound on the length of a target channel name
+const maxChannelLength = 64
+
+// Upper bound on a client-supplied fallback text, in characters
+const maxFallbackTextLength = 500
+
 var (
-	appConfig config
-	sessionsClient *cx.SessionsClient
+	appConfig      config
+	sessionsClient sessionsAPI
+	agentsClient   *cx.AgentsClient
+	intentsClient  intentsAPI
+	agentNames     *agentNameCache
 )
 
 func main() {
@@ -51,45 +180,95 @@ func main() {
 	// --- Load Configuration from Environment Variables ---
 	appConfig = loadConfig()
 
-	// --- Initialize Dialogflow CX Client ---
-	// Construct the regional endpoint string based on the LocationID config
-	// CX uses the same regional endpoint format as ES
-	regionalEndpoint := fmt.Sprintf("%s-dialogflow.googleapis.com:443", appConfig.LocationID)
-	log.Printf("Using Dialogflow CX regional endpoint: %s", regionalEndpoint)
+	// --- Mock Mode (local development / CI, no Dialogflow CX) ---
+	if appConfig.MockFixture != "" {
+		sessionsClient, err = newMockSessionsClient(appConfig.MockFixture)
+		if err != nil {
+			log.Fatalf("Failed to load MOCK_MODE fixture: %v", err)
+		}
+		log.Printf("MOCK_MODE: answering from %s; Dialogflow CX is not called", appConfig.MockFixture)
+	} else {
+		initDialogflowClients(ctx)
+		defer sessionsClient.Close()
+		defer intentsClient.Close()
+		if agentsClient != nil {
+			defer agentsClient.Close()
+		}
+	}
 
-	// ** UPDATED Client Initialization for CX **
-	sessionsClient, err = cx.NewSessionsClient(ctx, option.WithEndpoint(regionalEndpoint))
-	if err != nil {
-		log.Fatalf("Failed to create Dialogflow CX sessions client: %v", err)
+	// --- Global Dialogflow Rate Limit (optional) ---
+	dialogflowLimiter = newDialogflowLimiter(appConfig.GlobalDialogflowRPS)
+	if dialogflowLimiter != nil {
+		log.Printf("Global Dialogflow rate limit: %g requests/second", appConfig.GlobalDialogflowRPS)
 	}
-	defer sessionsClient.Close()
 
-	log.Printf("Dialogflow CX client initialized for project %s, location %s", appConfig.ProjectID, appConfig.LocationID)
+	// --- Concurrent Dialogflow Call Limit ---
+	if appConfig.MaxConcurrentDialogflowCalls > 0 {
+		dialogflowCallSlots = make(chan struct{}, appConfig.MaxConcurrentDialogflowCalls)
+	}
+
+	// --- Response Cache (optional) ---
+	if appConfig.ResponseCacheEnabled {
+		responseCache = newDetectIntentCache(appConfig.ResponseCacheTTL, appConfig.ResponseCacheMaxEntries)
+		responseCache.startSweep(ctx)
+		log.Printf("Response cache enabled (TTL %v, at most %d entries)", appConfig.ResponseCacheTTL, appConfig.ResponseCacheMaxEntries)
+	}
+
+	// --- Per-Minute Quota Counter ---
+	startQuotaReset(ctx)
+
+	// --- Background Credential Check ---
+	credentialRefreshHealthy.Set(1)
+	if appConfig.MockFixture == "" {
+		startCredentialCheck(ctx, appConfig.CredentialCheckInterval)
+	}
+
+	// --- Adaptive Load Shedding (optional) ---
+	// Only the client-facing Dialogflow endpoints are shed; health checks
+	// and admin endpoints always go through
+	shedLoad := func(next http.Handler) http.Handler { return next }
+	if appConfig.LoadShedLatency > 0 {
+		loadShed = newLoadShedder(appConfig.LoadShedLatency, appConfig.LoadShedFraction, appConfig.LoadShedWindow)
+		loadShed.start(ctx)
+		shedLoad = loadShed.middleware
+		log.Printf("Load shedding above %v p95 latency (fraction %g, window %v)", appConfig.LoadShedLatency, appConfig.LoadShedFraction, appConfig.LoadShedWindow)
+	}
 
 	// --- Setup HTTP Server & Routing ---
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/dialogflow/detectIntent", detectIntentHandler)
-	mux.HandleFunc("/healthz", healthCheckHandler)
+	mux := newRouter(shedLoad)
 
 	// --- CORS Configuration ---
-	c := cors.New(cors.Options{
-		AllowedOrigins: []string{appConfig.AllowedOrigin},
-		AllowedMethods: []string{"POST", "OPTIONS"},
-		AllowedHeaders: []string{"Content-Type", "Authorization"},
-		OptionsPassthrough: false,
-		Debug:              os.Getenv("CORS_DEBUG") == "true",
-	})
-	handler := c.Handler(mux)
+	// GET is always allowed for /api/health; detectIntent still rejects it
+	// unless ALLOW_GET_DETECT is set
+	allowedMethods := []string{"POST", "OPTIONS", "GET"}
+	corsHandler := routeAwareCORSMiddleware(defaultCORSOptions(allowedMethods), appConfig.CORSRoutes)
+
+	// --- Middleware Stack (outermost first) ---
+	// CORS answers pre-flights before anything else runs; the request ID
+	// and CSP apply to every response, including errors from the body check
+	stack := []middleware{corsHandler, requestIDMiddleware, cspMiddleware(appConfig.CSPHeader)}
+	if len(appConfig.ResponseHeaders) > 0 {
+		stack = append(stack, responseHeadersMiddleware(appConfig.ResponseHeaders))
+	}
+	stack = append(stack, requestBodyMiddleware(appConfig.MaxRequestBodyBytes))
+	if appConfig.Debug {
+		stack = append(stack, timeoutHeadersMiddleware(appConfig.ServerWriteTimeout, appConfig.DialogflowTimeout))
+	}
+	if appConfig.SLOLatency > 0 {
+		stack = append(stack, sloMiddleware(appConfig.SLOLatency)) // Must wrap the mux directly
+	}
+	handler := chain(mux, stack...)
 
 	// --- Start Server ---
 	log.Printf("Server starting on port %s", appConfig.Port)
 	log.Printf("Allowed CORS origin: %s", appConfig.AllowedOrigin)
+	log.Printf("Allowed CORS headers: %s", strings.Join(appConfig.AllowedHeaders, ", "))
 
 	server := &http.Server{
 		Addr:         ":" + appConfig.Port,
 		Handler:      handler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		ReadTimeout:  appConfig.ServerReadTimeout,
+		WriteTimeout: appConfig.ServerWriteTimeout,
 		IdleTimeout:  120 * time.Second,
 	}
 
@@ -98,18 +277,263 @@ func main() {
 	}
 }
 
+// Returns the Dialogflow CX endpoint for a location. CX uses the same
+// regional endpoint format as ES, unless DIALOGFLOW_ENDPOINT_OVERRIDES
+// maps the location to another host.
+func dialogflowEndpoint(locationID string, overrides map[string]string) string {
+	if endpoint, ok := overrides[locationID]; ok {
+		return endpoint
+	}
+	return fmt.Sprintf("%s-dialogflow.googleapis.com:443", locationID)
+}
+
+// Creates the Dialogflow CX clients for the configured location. Callers
+// close them on shutdown.
+func initDialogflowClients(ctx context.Context) {
+	// --- Initialize Dialogflow CX Client ---
+	regionalEndpoint := dialogflowEndpoint(appConfig.LocationID, appConfig.RegionEndpoints)
+	log.Printf("Using Dialogflow CX regional endpoint: %s", regionalEndpoint)
+
+	// ** UPDATED Client Initialization for CX **
+	client, err := cx.NewSessionsClient(ctx, option.WithEndpoint(regionalEndpoint))
+	if err != nil {
+		log.Fatalf("Failed to create Dialogflow CX sessions client: %v", err)
+	}
+	sessionsClient = client
+
+	log.Printf("Dialogflow CX client initialized for project %s, location %s", appConfig.ProjectID, appConfig.LocationID)
+
+	intents, err := cx.NewIntentsClient(ctx, option.WithEndpoint(regionalEndpoint))
+	if err != nil {
+		log.Fatalf("Failed to create Dialogflow CX intents client: %v", err)
+	}
+	intentsClient = cxIntentsClient{intents}
+
+	// --- Agent Display Names (optional) ---
+	if appConfig.IncludeAgentName {
+		agentsClient, err = cx.NewAgentsClient(ctx, option.WithEndpoint(regionalEndpoint))
+		if err != nil {
+			log.Fatalf("Failed to create Dialogflow CX agents client: %v", err)
+		}
+		agentNames = newAgentNameCache(appConfig.AgentNameCacheTTL)
+	}
+}
+
 // Loads configuration from environment variables with defaults
 func loadConfig() config {
+	loadEnvFile()
+
 	cfg := config{
-		ProjectID:     getEnv("DIALOGFLOW_PROJECT_ID", ""),
-		LocationID:    getEnv("DIALOGFLOW_LOCATION_ID", ""),
-		AllowedOrigin: getEnv("ALLOWED_ORIGIN", "*"),
-		Port:          getEnv("PORT", "8080"),
-		DefaultAgentID: getEnv("DEFAULT_DIALOGFLOW_AGENT_ID", "1891c50e-e0b6-44cc-b1f0-cc7d04bc73b2"), 
+		ProjectID:                    getEnv("DIALOGFLOW_PROJECT_ID", ""),
+		LocationID:                   getEnv("DIALOGFLOW_LOCATION_ID", ""),
+		AllowedOrigin:                getEnv("ALLOWED_ORIGIN", "*"),
+		Port:                         getEnv("PORT", "8080"),
+		DefaultAgentID:               getEnv("DEFAULT_DIALOGFLOW_AGENT_ID", "1891c50e-e0b6-44cc-b1f0-cc7d04bc73b2"),
+		AllowedHeaders:               getEnvList("CORS_ALLOWED_HEADERS", "Content-Type,Authorization"),
+		PayloadKeyAllowlist:          getEnvList("PAYLOAD_KEY_ALLOWLIST", ""),
+		PayloadKeyAllowlistRecursive: getEnv("PAYLOAD_KEY_ALLOWLIST_RECURSIVE", "false") == "true",
+		AllowGetDetect:               getEnv("ALLOW_GET_DETECT", "false") == "true",
+		EnablePprof:                  getEnv("ENABLE_PPROF", "false") == "true",
+		PprofAPIKey:                  getEnv("PPROF_API_KEY", ""),
+		SynthesizePayloadText:        getEnv("SYNTHESIZE_PAYLOAD_TEXT", "false") == "true",
+		CSPHeader:                    getEnv("CSP_HEADER", "default-src 'self'; script-src 'self'; style-src 'self'"),
+		MultilangPrefixFormat:        getEnv("MULTILANG_PREFIX_FORMAT", ""),
+		ResponseEnvelope:             getEnv("RESPONSE_ENVELOPE", "false") == "true",
+		PrettyJSON:                   getEnv("PRETTY_JSON", "false") == "true",
+		APIKey:                       getEnv("API_KEY", ""),
+		IncludeEntities:              getEnv("INCLUDE_ENTITIES", "false") == "true",
+		WebhookForwardURLs:           getEnvList("WEBHOOK_FORWARD_URLS", ""),
+		WebhookForwardKey:            getEnv("WEBHOOK_FORWARD_KEY", ""),
+		ResponseFilterFallbackText:   getEnv("RESPONSE_FILTER_FALLBACK_TEXT", "Sorry, I can't share that response."),
+		Debug:                        getEnv("DEBUG", "false") == "true",
+		DefaultEmptyResponseText:     getEnv("DEFAULT_EMPTY_RESPONSE_TEXT", ""),
+		AutoSessionCookie:            getEnv("AUTO_SESSION_COOKIE", "false") == "true",
+		AutoSessionID:                getEnv("AUTO_SESSION_ID", "false") == "true",
+		LineChannelSecret:            getEnv("LINE_CHANNEL_SECRET", ""),
+		LineChannelAccessToken:       getEnv("LINE_CHANNEL_ACCESS_TOKEN", ""),
+		LineReplyURL:                 getEnv("LINE_REPLY_URL", defaultLineReplyURL),
+		UTF8Mode:                     getEnv("UTF8_MODE", utf8ModeReject),
+		TeamsAppID:                   getEnv("TEAMS_APP_ID", ""),
+		PingAgentID:                  getEnv("PING_AGENT_ID", ""),
+		ResponseCacheEnabled:         getEnv("RESPONSE_CACHE_ENABLED", "false") == "true",
+		OutboundHMACKey:              getEnv("PICOLO_OUTBOUND_HMAC_KEY", ""),
+		PingSessionID:                getEnv("PING_SESSION_ID", "picolo-ping"),
+		TeamsAppPassword:             getEnv("TEAMS_APP_PASSWORD", ""),
+		TeamsOpenIDURL:               getEnv("TEAMS_OPENID_URL", defaultTeamsOpenIDURL),
+		TeamsTokenURL:                getEnv("TEAMS_TOKEN_URL", defaultTeamsTokenURL),
+		CORSAllowCredentials:         getEnv("CORS_ALLOW_CREDENTIALS", "false") == "true",
+		NoAgentErrorMessage:          getEnv("NO_AGENT_ERROR_MESSAGE", "An agent is required: send agentId in the request, or set DEFAULT_DIALOGFLOW_AGENT_ID on the server"),
 	}
 	if cfg.ProjectID == "" || cfg.LocationID == "" {
 		log.Fatal("Error: DIALOGFLOW_PROJECT_ID and DIALOGFLOW_LOCATION_ID environment variables must be set.")
 	}
+	if cfg.DefaultAgentID == "" {
+		log.Printf("Warning: DEFAULT_DIALOGFLOW_AGENT_ID is empty; requests without agentId will be rejected.")
+	}
+	if cfg.DefaultAgentID != "" {
+		// Surfaces project or location typos at startup, not on the first request
+		if _, err := buildAgentPath(cfg.ProjectID, cfg.LocationID, cfg.DefaultAgentID); err != nil {
+			log.Printf("Warning: %v; requests using it will be rejected with 400.", err)
+		}
+	}
+	if err := checkCORSCredentials(cfg.AllowedOrigin, cfg.CORSAllowCredentials); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if len(cfg.WebhookForwardURLs) > 0 && cfg.WebhookForwardKey == "" {
+		log.Fatal("Error: WEBHOOK_FORWARD_KEY must be set when WEBHOOK_FORWARD_URLS is set.")
+	}
+	if cfg.LineChannelSecret != "" && cfg.LineChannelAccessToken == "" {
+		log.Fatal("Error: LINE_CHANNEL_ACCESS_TOKEN must be set when LINE_CHANNEL_SECRET is set.")
+	}
+	if cfg.TeamsAppID != "" && cfg.TeamsAppPassword == "" {
+		log.Fatal("Error: TEAMS_APP_PASSWORD must be set when TEAMS_APP_ID is set.")
+	}
+	if cfg.UTF8Mode != utf8ModeReject && cfg.UTF8Mode != utf8ModeSanitize {
+		log.Fatalf("Error: UTF8_MODE must be %q or %q, got %q", utf8ModeReject, utf8ModeSanitize, cfg.UTF8Mode)
+	}
+	if cfg.EnablePprof && cfg.PprofAPIKey == "" {
+		log.Fatal("Error: PPROF_API_KEY must be set when ENABLE_PPROF is true.")
+	}
+	hints, err := parseHeaderHints(getEnv("RESPONSE_HEADER_HINTS", defaultHeaderHints))
+	if err != nil {
+		log.Fatalf("Error: RESPONSE_HEADER_HINTS: %v", err)
+	}
+	cfg.HeaderHints = hints
+	responseHeaders, err := parseResponseHeaders(getEnv("RESPONSE_HEADERS", ""))
+	if err != nil {
+		log.Fatalf("Error: RESPONSE_HEADERS: %v", err)
+	}
+	cfg.ResponseHeaders = responseHeaders
+	apiKeys, err := parseAPIKeys(getEnv("API_KEYS", ""))
+	if err != nil {
+		log.Fatalf("Error: API_KEYS: %v", err)
+	}
+	cfg.APIKeys = apiKeys
+	filter, err := newWordFilter(getEnvList("RESPONSE_FILTER_WORDS", ""), getEnv("RESPONSE_FILTER_FILE", ""), getEnv("RESPONSE_FILTER_MODE", filterModeMask))
+	if err != nil {
+		log.Fatalf("Error: response filter: %v", err)
+	}
+	cfg.ResponseFilter = filter
+	interval, err := time.ParseDuration(getEnv("CREDENTIAL_CHECK_INTERVAL", "5m"))
+	if err != nil {
+		log.Fatalf("Error: CREDENTIAL_CHECK_INTERVAL: %v", err)
+	}
+	cfg.CredentialCheckInterval = interval
+	cfg.IncludeAgentName = getEnv("INCLUDE_AGENT_NAME", "false") == "true"
+	agentNameTTL, err := time.ParseDuration(getEnv("AGENT_NAME_CACHE_TTL", "10m"))
+	if err != nil {
+		log.Fatalf("Error: AGENT_NAME_CACHE_TTL: %v", err)
+	}
+	cfg.AgentNameCacheTTL = agentNameTTL
+	readTimeout, err := time.ParseDuration(getEnv("SERVER_READ_TIMEOUT", "10s"))
+	if err != nil {
+		log.Fatalf("Error: SERVER_READ_TIMEOUT: %v", err)
+	}
+	cfg.ServerReadTimeout = readTimeout
+	writeTimeout, err := time.ParseDuration(getEnv("SERVER_WRITE_TIMEOUT", "10s"))
+	if err != nil {
+		log.Fatalf("Error: SERVER_WRITE_TIMEOUT: %v", err)
+	}
+	cfg.ServerWriteTimeout = writeTimeout
+	dialogflowTimeout, err := time.ParseDuration(getEnv("DIALOGFLOW_TIMEOUT", "30s"))
+	if err != nil || dialogflowTimeout <= 0 {
+		log.Fatalf("Error: DIALOGFLOW_TIMEOUT must be a positive duration, got %q", getEnv("DIALOGFLOW_TIMEOUT", "30s"))
+	}
+	cfg.DialogflowTimeout = dialogflowTimeout
+	agentTimeouts, err := parseAgentTimeouts(getEnvList("AGENT_TIMEOUTS", ""))
+	if err != nil {
+		log.Fatalf("Error: AGENT_TIMEOUTS: %v", err)
+	}
+	cfg.AgentTimeouts = agentTimeouts
+	rps, err := strconv.ParseFloat(getEnv("GLOBAL_DIALOGFLOW_RPS", "0"), 64)
+	if err != nil || rps < 0 {
+		log.Fatalf("Error: GLOBAL_DIALOGFLOW_RPS must be a non-negative number, got %q", getEnv("GLOBAL_DIALOGFLOW_RPS", "0"))
+	}
+	cfg.GlobalDialogflowRPS = rps
+	maxBody, err := strconv.ParseInt(getEnv("MAX_REQUEST_BODY_BYTES", "1048576"), 10, 64)
+	if err != nil || maxBody <= 0 {
+		log.Fatalf("Error: MAX_REQUEST_BODY_BYTES must be a positive integer, got %q", getEnv("MAX_REQUEST_BODY_BYTES", "1048576"))
+	}
+	cfg.MaxRequestBodyBytes = maxBody
+	quotaLimit, err := strconv.ParseInt(getEnv("DIALOGFLOW_QUOTA_LIMIT_PER_MINUTE", "0"), 10, 64)
+	if err != nil || quotaLimit < 0 {
+		log.Fatalf("Error: DIALOGFLOW_QUOTA_LIMIT_PER_MINUTE must be a non-negative integer, got %q", getEnv("DIALOGFLOW_QUOTA_LIMIT_PER_MINUTE", "0"))
+	}
+	cfg.QuotaLimitPerMinute = quotaLimit
+	sloMillis, err := strconv.Atoi(getEnv("SLO_LATENCY_MS", "0"))
+	if err != nil || sloMillis < 0 {
+		log.Fatalf("Error: SLO_LATENCY_MS must be a non-negative integer, got %q", getEnv("SLO_LATENCY_MS", "0"))
+	}
+	cfg.SLOLatency = time.Duration(sloMillis) * time.Millisecond
+	shedMillis, err := strconv.Atoi(getEnv("LOAD_SHED_P95_MS", "0"))
+	if err != nil || shedMillis < 0 {
+		log.Fatalf("Error: LOAD_SHED_P95_MS must be a non-negative integer, got %q", getEnv("LOAD_SHED_P95_MS", "0"))
+	}
+	cfg.LoadShedLatency = time.Duration(shedMillis) * time.Millisecond
+	shedFraction, err := strconv.ParseFloat(getEnv("LOAD_SHED_FRACTION", "0.5"), 64)
+	if err != nil || shedFraction <= 0 || shedFraction > 1 {
+		log.Fatalf("Error: LOAD_SHED_FRACTION must be a number in (0, 1], got %q", getEnv("LOAD_SHED_FRACTION", "0.5"))
+	}
+	cfg.LoadShedFraction = shedFraction
+	shedWindow, err := time.ParseDuration(getEnv("LOAD_SHED_WINDOW", "30s"))
+	if err != nil || shedWindow <= 0 {
+		log.Fatalf("Error: LOAD_SHED_WINDOW must be a positive duration, got %q", getEnv("LOAD_SHED_WINDOW", "30s"))
+	}
+	cfg.LoadShedWindow = shedWindow
+	pingInterval, err := time.ParseDuration(getEnv("PING_MIN_INTERVAL", "10s"))
+	if err != nil || pingInterval < 0 {
+		log.Fatalf("Error: PING_MIN_INTERVAL must be a non-negative duration, got %q", getEnv("PING_MIN_INTERVAL", "10s"))
+	}
+	cfg.PingMinInterval = pingInterval
+	cacheTTL, err := time.ParseDuration(getEnv("RESPONSE_CACHE_TTL", "5m"))
+	if err != nil || cacheTTL <= 0 {
+		log.Fatalf("Error: RESPONSE_CACHE_TTL must be a positive duration, got %q", getEnv("RESPONSE_CACHE_TTL", "5m"))
+	}
+	cfg.ResponseCacheTTL = cacheTTL
+	cacheMaxEntries, err := strconv.Atoi(getEnv("RESPONSE_CACHE_MAX_ENTRIES", "10000"))
+	if err != nil || cacheMaxEntries <= 0 {
+		log.Fatalf("Error: RESPONSE_CACHE_MAX_ENTRIES must be a positive integer, got %q", getEnv("RESPONSE_CACHE_MAX_ENTRIES", "10000"))
+	}
+	cfg.ResponseCacheMaxEntries = cacheMaxEntries
+	if cfg.PingAgentID != "" {
+		if _, err := buildSessionPath(cfg.ProjectID, cfg.LocationID, cfg.PingAgentID, cfg.PingSessionID); err != nil {
+			log.Fatalf("Error: PING_AGENT_ID/PING_SESSION_ID: %v", err)
+		}
+	}
+	sessionTTL, err := strconv.Atoi(getEnv("SESSION_TTL_SECONDS", "1800"))
+	if err != nil || sessionTTL <= 0 {
+		log.Fatalf("Error: SESSION_TTL_SECONDS must be a positive integer, got %q", getEnv("SESSION_TTL_SECONDS", "1800"))
+	}
+	cfg.SessionTTLSeconds = sessionTTL
+	maxCalls, err := strconv.Atoi(getEnv("MAX_CONCURRENT_DIALOGFLOW_CALLS", "50"))
+	if err != nil || maxCalls < 0 {
+		log.Fatalf("Error: MAX_CONCURRENT_DIALOGFLOW_CALLS must be a non-negative integer, got %q", getEnv("MAX_CONCURRENT_DIALOGFLOW_CALLS", "50"))
+	}
+	cfg.MaxConcurrentDialogflowCalls = maxCalls
+	minParts, err := strconv.Atoi(getEnv("STREAMING_RESPONSE_MIN_PARTS", "3"))
+	if err != nil || minParts < 0 {
+		log.Fatalf("Error: STREAMING_RESPONSE_MIN_PARTS must be a non-negative integer, got %q", getEnv("STREAMING_RESPONSE_MIN_PARTS", "3"))
+	}
+	cfg.StreamingResponseMinParts = minParts
+	corsRoutes, err := parseCORSRoutes(getEnv("CORS_ROUTES_CONFIG", ""), cfg.CORSAllowCredentials)
+	if err != nil {
+		log.Fatalf("Error: CORS_ROUTES_CONFIG: %v", err)
+	}
+	cfg.CORSRoutes = corsRoutes
+	if overrides := getEnv("DIALOGFLOW_ENDPOINT_OVERRIDES", ""); overrides != "" {
+		if err := json.Unmarshal([]byte(overrides), &cfg.RegionEndpoints); err != nil {
+			log.Fatalf("Error: DIALOGFLOW_ENDPOINT_OVERRIDES must be a JSON object of location to endpoint: %v", err)
+		}
+	}
+	if getEnv("MOCK_MODE", "false") == "true" {
+		cfg.MockFixture = getEnv("MOCK_FIXTURE_FILE", "")
+		if cfg.MockFixture == "" {
+			log.Fatal("Error: MOCK_FIXTURE_FILE must be set when MOCK_MODE is true.")
+		}
+		// These look up agent details in Dialogflow CX
+		cfg.IncludeEntities = false
+		cfg.IncludeAgentName = false
+	}
 	return cfg
 }
 
@@ -121,38 +545,413 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// Helper to get a comma-separated environment variable as a slice,
+// trimming whitespace and dropping empty entries
+func getEnvList(key, fallback string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, fallback), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Parses AGENT_TIMEOUTS entries of the form agentId=duration
+func parseAgentTimeouts(entries []string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	for _, entry := range entries {
+		agentID, value, ok := strings.Cut(entry, "=")
+		agentID = strings.TrimSpace(agentID)
+		if !ok || agentID == "" {
+			return nil, fmt.Errorf("invalid entry %q: expected agentId=duration", entry)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("agent %s: timeout must be a positive duration, got %q", agentID, value)
+		}
+		timeouts[agentID] = timeout
+	}
+	return timeouts, nil
+}
+
+// Deadline for an agent's Dialogflow CX calls: its AGENT_TIMEOUTS entry,
+// or DIALOGFLOW_TIMEOUT
+func dialogflowTimeout(agentID string) time.Duration {
+	if timeout, ok := appConfig.AgentTimeouts[agentID]; ok {
+		return timeout
+	}
+	return appConfig.DialogflowTimeout
+}
+
+// Returns the context for a request's Dialogflow CX calls to an agent, with
+// its dialogflowTimeout. The connection's write deadline
+// (SERVER_WRITE_TIMEOUT) is pushed out past that timeout, so a slow agent,
+// a long stream or a replay still ends with a response rather than a
+// dropped connection.
+func dialogflowContext(w http.ResponseWriter, r *http.Request, agentID string) (context.Context, context.CancelFunc) {
+	timeout := dialogflowTimeout(agentID)
+	if appConfig.ServerWriteTimeout > 0 {
+		err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + appConfig.ServerWriteTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("Error extending write deadline: %v", err)
+		}
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
 // Simple health check endpoint
+// (?deep=true adds JSON detail such as draining agents)
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("deep") == "true" {
+		writeResponse(w, r, http.StatusOK, deepHealthResponse{
+			Status:         "ok",
+			DrainingAgents: drainingAgentIDs(),
+		})
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
+// JSON health for browser dashboards and monitoring tools; /healthz stays
+// the plain load balancer probe
+func apiHealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, apiHealthResponse{
+		Status: "ok",
+		Time:   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+type apiHealthResponse struct {
+	Status string `json:"status"`
+	Time   string `json:"time"`
+}
+
+type deepHealthResponse struct {
+	Status         string   `json:"status"`
+	DrainingAgents []string `json:"drainingAgents"`
+}
+
+// Enforces the allowed methods for an endpoint; routes get it through
+// handleMethods, so handlers never check methods. OPTIONS is answered directly
+// with an Allow header (CORS pre-flights are handled earlier by the CORS
+// middleware), and any other method gets a JSON 405. Returns false when the
+// request has already been answered.
+func checkMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	writeJSONError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	return false
+}
+
+// Methods detectIntent accepts: POST, and GET with ALLOW_GET_DETECT
+func detectIntentMethods() []string {
+	if appConfig.AllowGetDetect {
+		return []string{http.MethodPost, http.MethodGet}
+	}
+	return []string{http.MethodPost}
+}
+
 // Handles requests to the /api/dialogflow/detectIntent endpoint for CX
 func detectIntentHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	req, ok := readDetectIntentRequest(w, r)
+	if !ok {
 		return
 	}
 
-	// --- Decode Request Body ---
-	var req DetectIntentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	applySessionCookie(w, r, &req)
+
+	// --- Agent Override (QA, needs the agent_override permission) ---
+	if override := r.Header.Get("X-Override-Agent-ID"); override != "" {
+		if !hasKeyPermission(r, appConfig.APIKeys, permAgentOverride) {
+			writeJSONError(w, r, http.StatusForbidden, "X-Override-Agent-ID requires an API key with the agent_override permission")
+			return
+		}
+		log.Printf("Warning: Agent overridden by X-Override-Agent-ID: %q -> %q", req.AgentID, override)
+		req.AgentID = override
+	}
+
+	// --- Dry Run (integration tests, needs API_KEY) ---
+	if isDryRun(r) && !validBearerToken(r, appConfig.APIKey) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONError(w, r, http.StatusUnauthorized, "X-Dry-Run requires Authorization with the API key")
 		return
 	}
-	defer r.Body.Close()
 
 	// --- Input Validation ---
+	call, err := prepareDetectIntent(req)
+	if err != nil {
+		log.Printf("Validation Error: %v", err)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if isDryRun(r) {
+		writeDryRun(w, r, call)
+		return
+	}
+	if isDraining(call.agentID) {
+		log.Printf("Rejected request for draining agent %s", call.agentID)
+		writeRetryableError(w, r, http.StatusServiceUnavailable, "Agent is draining, retry shortly", drainRetryAfterSeconds)
+		return
+	}
+
+	// Upgrade to Server-Sent Events only when asked for and supported
+	if req.Stream && acceptsMediaType(r, contentTypeEventStream) {
+		streamDetectIntent(w, r, call, true)
+		return
+	}
+
+	// --- Send Request(s) to Dialogflow CX ---
+	ctx, cancel := dialogflowContext(w, r, call.agentID)
+	defer cancel()
+
+	apiResponse, err := cachedDetectIntentCore(ctx, w.Header(), call)
+	if err != nil {
+		writeDetectIntentError(w, r, err)
+		return
+	}
+
+	// Large replies go out part by part to clients that can render them so
+	if acceptsMediaType(r, contentTypeNDJSON) && manyResponseParts(apiResponse.parts) {
+		writeResponseParts(w, r, apiResponse)
+		return
+	}
+
+	// Plain-text clients (SMS gateways, legacy integrations) get the reply only
+	if acceptsMediaType(r, contentTypeText) && !acceptsMediaType(r, contentTypeJSON) {
+		writePlainText(w, http.StatusOK, apiResponse.displayText())
+		return
+	}
+	writeResponse(w, r, http.StatusOK, apiResponse)
+}
+
+// Dialogflow CX returned a response without a query result
+var errEmptyQueryResult = errors.New("Dialogflow CX returned empty result")
+
+// Runs a validated call against Dialogflow CX and builds the client
+// response, independent of how the request arrived (API or a channel
+// webhook). Header hints are set on h. Errors are reported with
+// writeDetectIntentError.
+func detectIntentCore(ctx context.Context, h http.Header, call *detectIntentCall) (DetectIntentResponse, error) {
+	// Inputs are sent one after another on the same session, so each turn
+	// sees the session state left by the previous one
+	var turns []TurnResponse
+	var lastParts []streamLine
+	for _, input := range call.inputs {
+		log.Printf("Sending CX request to Dialogflow: Path=%s, Lang=%s, Message=%q, Event=%q",
+			call.sessionPath, call.langCode, input.Message, input.Event)
+
+		if err := waitDialogflowQuota(ctx); err != nil {
+			return DetectIntentResponse{}, err
+		}
+		if err := acquireDialogflowCall(); err != nil {
+			return DetectIntentResponse{}, err
+		}
+
+		// ** UPDATED API call for CX **
+		start := time.Now()
+		response, err := sessionsClient.DetectIntent(ctx, call.dialogflowRequest(input))
+		recordDialogflowLatency(time.Since(start))
+		releaseDialogflowCall()
+		countDetectIntentCall()
+		if err != nil {
+			log.Printf("Error calling Dialogflow CX DetectIntent: %v", err)
+			return DetectIntentResponse{}, err
+		}
+
+		queryResult := response.GetQueryResult()
+		if queryResult == nil {
+			log.Printf("Error: Dialogflow CX response missing query result.")
+			return DetectIntentResponse{}, errEmptyQueryResult
+		}
+
+		queryResult.ResponseMessages = filterMessagesByChannel(queryResult.GetResponseMessages(), call.channel)
+		applyHeaderHints(h, queryResult.GetResponseMessages(), appConfig.HeaderHints)
+
+		turn := extractTurnResponse(queryResult)
+		if call.raw {
+			// protojson keeps proto field names, enums and well-known types
+			if turn.RawQueryResult, err = protojson.Marshal(queryResult); err != nil {
+				log.Printf("Error marshaling raw query result: %v", err)
+			}
+		}
+		if appConfig.IncludeEntities {
+			turn.Entities = extractEntities(ctx, queryResult)
+		}
+		if turn.Text == "" {
+			log.Printf("Warning: No text response found in Dialogflow CX result.")
+			turn.Text = call.emptyReplyText()
+			turn.WasFallback = turn.Text != ""
+		}
+		log.Printf("Received response from Dialogflow CX: Fulfillment=%q", turn.Text)
+
+		// --- Response Content Filter ---
+		turn.Text = call.filterText(turn.Text)
+
+		// SSML goes to TTS as-is; chat clients show the stripped text
+		if containsSSML(turn.Text) {
+			turn.SSML, turn.SpeakText = turn.Text, stripSSML(turn.Text)
+		}
+		turns = append(turns, turn)
+		lastParts = call.replyLines(queryResult)
+	}
+
+	// ** UPDATED Response format **
+	// The top-level fields reflect the last turn
+	lastTurn := turns[len(turns)-1]
+	apiResponse := DetectIntentResponse{
+		Text:           lastTurn.Text,
+		SessionID:      call.sessionID,
+		RichContent:    lastTurn.RichContent,
+		Entities:       lastTurn.Entities,
+		WasFallback:    lastTurn.WasFallback,
+		AudioURIs:      lastTurn.AudioURIs,
+		RawQueryResult: lastTurn.RawQueryResult,
+		WebhookStatus:  lastTurn.WebhookStatus,
+		WebhookMessage: lastTurn.WebhookMessage,
+		SpeakText:      lastTurn.SpeakText,
+		SSML:           lastTurn.SSML,
+		BillableUnits:  len(turns), // One per Dialogflow CX call
+		parts:          lastParts,
+	}
+	if call.multiInput {
+		apiResponse.Responses = turns
+	}
+	if agentNames != nil {
+		apiResponse.AgentName = agentNames.get(ctx, call.agentID)
+	}
+	return apiResponse, nil
+}
+
+// Writes an error from detectIntentCore (or the Dialogflow call limits) with
+// the matching status
+func writeDetectIntentError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errDialogflowRateLimited):
+		writeRetryableError(w, r, http.StatusTooManyRequests, "Dialogflow rate limit reached, retry shortly", 1)
+	case errors.Is(err, errDialogflowBusy):
+		writeRetryableError(w, r, http.StatusServiceUnavailable, "Too many concurrent Dialogflow calls, retry shortly", 1)
+	case errors.Is(err, errEmptyQueryResult):
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		writeDialogflowError(w, r, err)
+	}
+}
+
+// Reads the client request from the query string (GET) or the verified
+// request body. Writes the error response and returns false on failure.
+func readDetectIntentRequest(w http.ResponseWriter, r *http.Request) (DetectIntentRequest, bool) {
+	var req DetectIntentRequest
+	if r.Method == http.MethodGet {
+		// --- Build Request from Query String (probes / smoke tests) ---
+		query := r.URL.Query()
+		req = DetectIntentRequest{
+			Message:      query.Get("message"),
+			AgentID:      query.Get("agentId"),
+			SessionID:    query.Get("sessionId"),
+			LanguageCode: query.Get("languageCode"),
+		}
+		return req, true
+	}
+
+	// --- Read and Verify Request Body ---
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		log.Printf("Error reading request body: %v", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+			return req, false
+		}
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return req, false
+	}
+	if checksum := r.Header.Get("X-Content-SHA256"); checksum != "" && !validBodyChecksum(body, checksum) {
+		log.Printf("Rejected request: body checksum mismatch")
+		writeJSONError(w, r, http.StatusBadRequest, "body checksum mismatch")
+		return req, false
+	}
+
+	// CBOR validates its own text strings while decoding
+	if !hasContentType(r, contentTypeCBOR) {
+		if body, err = checkBodyUTF8(body); err != nil {
+			log.Printf("Rejected request: %v", err)
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return req, false
+		}
+	}
+
+	// --- Decode Request Body ---
+	if err := decodeRequestBody(r, body, &req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return req, false
+	}
+	return req, true
+}
+
+// A validated client request resolved against the server configuration
+type detectIntentCall struct {
+	agentID      string
+	sessionID    string
+	langCode     string
+	sessionPath  string
+	inputs       []QueryInput
+	queryParams  *cxpb.QueryParameters
+	fallbackText string
+	multiInput   bool // Sent as inputs: report every turn in responses
+	raw          bool // Include the full QueryResult (DEBUG only)
+	channel      string
+}
+
+// Validates the client request and fills in defaults. Returned errors are
+// client errors, safe to send back as-is.
+func prepareDetectIntent(req DetectIntentRequest) (*detectIntentCall, error) {
 	agentID := req.AgentID
 	if agentID == "" {
 		agentID = appConfig.DefaultAgentID // Use default if not provided
 	}
+	if agentID == "" {
+		log.Printf("Validation Error: No agentId in request and no default agent configured")
+		return nil, errors.New(appConfig.NoAgentErrorMessage)
+	}
 	sessionID := req.SessionID // Use session ID from request
-	if req.Message == "" || agentID == "" || sessionID == "" {
+	if (req.Message == "" && len(req.Inputs) == 0) || sessionID == "" {
 		log.Printf("Validation Error: Missing message, agentId, or sessionId. AgentID used: %s, SessionID: %s", agentID, sessionID)
-		http.Error(w, "Missing required fields: message, agentId, sessionId", http.StatusBadRequest)
-		return
+		return nil, errors.New("Missing required fields: message, agentId, sessionId")
+	}
+	if req.Raw && !appConfig.Debug {
+		return nil, errors.New("raw is only available when the server runs with DEBUG")
+	}
+	if err := checkRequestUTF8(&req); err != nil {
+		return nil, err
+	}
+	if err := validateInputs(req); err != nil {
+		return nil, err
+	}
+	queryParams, err := buildQueryParams(req)
+	if err != nil {
+		return nil, err
+	}
+	if utf8.RuneCountInString(req.FallbackText) > maxFallbackTextLength {
+		return nil, fmt.Errorf("fallbackText too long: at most %d characters are allowed", maxFallbackTextLength)
+	}
+
+	// A plain message is a single text input
+	inputs := req.Inputs
+	if len(inputs) == 0 {
+		inputs = []QueryInput{{Message: req.Message}}
 	}
 
 	// --- Language Code ---
@@ -161,76 +960,302 @@ func detectIntentHandler(w http.ResponseWriter, r *http.Request) {
 		langCode = "en"
 	}
 
-	// --- Construct Dialogflow CX Request ---
-	sessionPath := fmt.Sprintf("projects/%s/locations/%s/agents/%s/sessions/%s",
-		appConfig.ProjectID, appConfig.LocationID, agentID, sessionID)
+	// --- Construct Dialogflow CX Session Path ---
+	sessionPath, err := buildSessionPath(appConfig.ProjectID, appConfig.LocationID, agentID, sessionID)
+	if err != nil {
+		return nil, err
+	}
 
-	log.Printf("Sending CX request to Dialogflow: Path=%s, Lang=%s, Message=%q",
-		sessionPath, langCode, req.Message)
+	return &detectIntentCall{
+		agentID:      agentID,
+		sessionID:    sessionID,
+		langCode:     langCode,
+		sessionPath:  sessionPath,
+		inputs:       inputs,
+		queryParams:  queryParams,
+		fallbackText: req.FallbackText,
+		multiInput:   len(req.Inputs) > 0,
+		raw:          req.Raw,
+		channel:      req.Channel,
+	}, nil
+}
 
+// Builds the Dialogflow CX request for one input of the call
+func (c *detectIntentCall) dialogflowRequest(input QueryInput) *cxpb.DetectIntentRequest {
 	// ** UPDATED Request struct for CX **
-	dialogflowRequest := &cxpb.DetectIntentRequest{
-		Session: sessionPath,
-		QueryInput: &cxpb.QueryInput{
-			Input: &cxpb.QueryInput_Text{
-				Text: &cxpb.TextInput{
-					Text: req.Message,
-				},
-			},
-			LanguageCode: langCode,
-		},
-	}
-
-	// --- Send Request to Dialogflow CX ---
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-	defer cancel()
+	return &cxpb.DetectIntentRequest{
+		Session:     c.sessionPath,
+		QueryInput:  toCXQueryInput(input, c.langCode),
+		QueryParams: c.queryParams,
+	}
+}
+
+// Reply used when the agent returns no text: the request's fallbackText
+// (client-supplied, e.g. localized), else DEFAULT_EMPTY_RESPONSE_TEXT
+func (c *detectIntentCall) emptyReplyText() string {
+	if c.fallbackText != "" {
+		return c.fallbackText
+	}
+	return appConfig.DefaultEmptyResponseText
+}
+
+// Applies RESPONSE_FILTER to reply text. In block mode a match replaces the
+// whole text with the request's fallbackText, else
+// RESPONSE_FILTER_FALLBACK_TEXT.
+func (c *detectIntentCall) filterText(text string) string {
+	filter := appConfig.ResponseFilter
+	if filter == nil || text == "" {
+		return text
+	}
+	filtered, found := filter.apply(text)
+	if !found || filter.mode != filterModeBlock {
+		return filtered
+	}
+	log.Printf("Response blocked by content filter")
+	if c.fallbackText != "" {
+		return c.fallbackText
+	}
+	return appConfig.ResponseFilterFallbackText
+}
 
-	// ** UPDATED API call for CX **
-	response, err := sessionsClient.DetectIntent(ctx, dialogflowRequest)
+// Checks the optional list of sequential inputs
+func validateInputs(req DetectIntentRequest) error {
+	if len(req.Inputs) == 0 {
+		return nil
+	}
+	if req.Message != "" {
+		return errors.New("Provide either message or inputs, not both")
+	}
+	if len(req.Inputs) > maxQueryInputs {
+		return fmt.Errorf("Too many inputs: at most %d are allowed", maxQueryInputs)
+	}
+	for i, input := range req.Inputs {
+		if (input.Message == "") == (input.Event == "") {
+			return fmt.Errorf("Input %d must set exactly one of message or event", i)
+		}
+	}
+	return nil
+}
+
+// Builds the query parameters sent with every turn of the request, or nil
+// when there is nothing to send
+func buildQueryParams(req DetectIntentRequest) (*cxpb.QueryParameters, error) {
+	var queryParams *cxpb.QueryParameters
+	if len(req.Flags) > 0 {
+		if err := validateFlags(req.Flags); err != nil {
+			return nil, err
+		}
+		// Flags go under a single reserved session parameter so they never
+		// collide with parameters collected by the agent
+		parameters, err := structpb.NewStruct(map[string]interface{}{flagsParameterKey: req.Flags})
+		if err != nil {
+			return nil, fmt.Errorf("Invalid flags: %v", err)
+		}
+		queryParams = &cxpb.QueryParameters{Parameters: parameters}
+	}
+	if req.Channel != "" {
+		if !validChannel(req.Channel) {
+			return nil, fmt.Errorf("Invalid channel %q: use 1-%d letters, digits, '-' or '_'", req.Channel, maxChannelLength)
+		}
+		if queryParams == nil {
+			queryParams = &cxpb.QueryParameters{}
+		}
+		queryParams.Channel = req.Channel // Lets CX pick channel-specific responses
+	}
+	return queryParams, nil
+}
+
+// Reports whether a channel name is short and made of safe characters
+func validChannel(channel string) bool {
+	if len(channel) > maxChannelLength {
+		return false
+	}
+	for _, c := range channel {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return channel != ""
+}
+
+// Keeps the messages for the given channel plus those without a channel,
+// which apply to every channel. All messages are kept when channel is "".
+func filterMessagesByChannel(messages []*cxpb.ResponseMessage, channel string) []*cxpb.ResponseMessage {
+	if channel == "" {
+		return messages
+	}
+	var filtered []*cxpb.ResponseMessage
+	for _, message := range messages {
+		if message.GetChannel() == "" || message.GetChannel() == channel {
+			filtered = append(filtered, message)
+		}
+	}
+	return filtered
+}
+
+// Checks the feature flag bag stays small enough to forward on every turn
+func validateFlags(flags map[string]interface{}) error {
+	if len(flags) > maxFlags {
+		return fmt.Errorf("Too many flags: at most %d are allowed", maxFlags)
+	}
+	for key := range flags {
+		if key == "" || len(key) > maxFlagKeyLength {
+			return fmt.Errorf("Invalid flag name %q: must be 1-%d characters", key, maxFlagKeyLength)
+		}
+	}
+	encoded, err := json.Marshal(flags)
 	if err != nil {
-		log.Printf("Error calling Dialogflow CX DetectIntent: %v", err)
-		http.Error(w, fmt.Sprintf("Dialogflow CX API error: %v", err), http.StatusInternalServerError)
-		return
+		return fmt.Errorf("Invalid flags: %v", err)
 	}
+	if len(encoded) > maxFlagsBytes {
+		return fmt.Errorf("Flags too large: at most %d bytes are allowed", maxFlagsBytes)
+	}
+	return nil
+}
 
-	// --- Process and Return Response (Simplified like JS example) ---
-	queryResult := response.GetQueryResult()
-	if queryResult == nil {
-		log.Printf("Error: Dialogflow CX response missing query result.")
-		http.Error(w, "Dialogflow CX returned empty result", http.StatusInternalServerError)
-		return
+// Converts a client input into a Dialogflow CX query input
+func toCXQueryInput(input QueryInput, langCode string) *cxpb.QueryInput {
+	queryInput := &cxpb.QueryInput{LanguageCode: langCode}
+	if input.Event != "" {
+		queryInput.Input = &cxpb.QueryInput_Event{
+			Event: &cxpb.EventInput{Event: input.Event},
+		}
+	} else {
+		queryInput.Input = &cxpb.QueryInput_Text{
+			Text: &cxpb.TextInput{Text: input.Message},
+		}
 	}
+	return queryInput
+}
 
-	responseText := ""
-	// Extract the first text response message, similar to the JS example
+// Keeps the texts prefixed for the given language (per MULTILANG_PREFIX_FORMAT,
+// e.g. "[EN] Hello" for "[%s] ") and strips the prefix. A regional code such
+// as "en-US" also matches the base language. All texts are returned when the
+// format is unset or no text matches.
+func filterTextsByLanguage(texts []string, lang string) []string {
+	prefix := languagePrefix(texts, lang)
+	if prefix == "" {
+		return texts
+	}
+	var filtered []string
+	for _, text := range texts {
+		if trimmed, ok := strings.CutPrefix(text, prefix); ok {
+			filtered = append(filtered, trimmed)
+		}
+	}
+	return filtered
+}
+
+// Returns the MULTILANG_PREFIX_FORMAT prefix of the texts in the given
+// language, or "" when the format is unset or no text matches
+func languagePrefix(texts []string, lang string) string {
+	format := appConfig.MultilangPrefixFormat
+	if format == "" || lang == "" {
+		return ""
+	}
+	lang = strings.ToUpper(lang)
+	candidates := []string{lang}
+	if base, _, regional := strings.Cut(lang, "-"); regional {
+		candidates = append(candidates, base)
+	}
+	for _, candidate := range candidates {
+		prefix := fmt.Sprintf(format, candidate)
+		for _, text := range texts {
+			if strings.HasPrefix(text, prefix) {
+				return prefix
+			}
+		}
+	}
+	return ""
+}
+
+// Extracts the reply from a single Dialogflow CX turn (Simplified like JS example)
+func extractTurnResponse(queryResult *cxpb.QueryResult) TurnResponse {
+	var turn TurnResponse
 	responseMessages := queryResult.GetResponseMessages()
-	if len(responseMessages) > 0 {
+	if appConfig.MultilangPrefixFormat != "" {
+		// Pick the first text in the turn's language from all text messages
+		var texts []string
+		for _, message := range responseMessages {
+			if textMessage := message.GetText(); textMessage != nil && len(textMessage.GetText()) > 0 {
+				texts = append(texts, textMessage.GetText()[0])
+			}
+		}
+		if texts = filterTextsByLanguage(texts, queryResult.GetLanguageCode()); len(texts) > 0 {
+			turn.Text = texts[0]
+		}
+	} else if len(responseMessages) > 0 {
+		// Extract the first text response message, similar to the JS example
 		// Check if the first message is a text message
 		if textMessage := responseMessages[0].GetText(); textMessage != nil {
 			// Get the list of texts (usually just one)
 			texts := textMessage.GetText()
 			if len(texts) > 0 {
-				responseText = texts[0]
+				turn.Text = texts[0]
 			}
 		}
 	}
 
-	if responseText == "" {
-		log.Printf("Warning: No text response found in Dialogflow CX result.")
+	// Collect custom payloads as typed rich content
+	for _, message := range responseMessages {
+		if payload := message.GetPayload(); payload != nil {
+			turn.RichContent = append(turn.RichContent, parseRichContent(payload))
+		}
+	}
+
+	// Pre-recorded audio clips, for voice clients
+	for _, message := range responseMessages {
+		if playAudio := message.GetPlayAudio(); playAudio != nil {
+			if uri := playAudio.GetAudioUri(); validAudioURI(uri) {
+				turn.AudioURIs = append(turn.AudioURIs, uri)
+			} else {
+				log.Printf("Warning: Skipping malformed play-audio URI %q", uri)
+			}
+		}
 	}
 
-	log.Printf("Received response from Dialogflow CX: Fulfillment=%q", responseText)
+	turn.WebhookStatus, turn.WebhookMessage = webhookStatus(queryResult)
 
-	// ** UPDATED Response format **
-	apiResponse := DetectIntentResponse{
-		Text:      responseText,
-		SessionID: sessionID,
+	// Give simple clients something to show for payload-only turns
+	if turn.Text == "" && appConfig.SynthesizePayloadText {
+		turn.Text = summarizeRichContent(turn.RichContent)
 	}
+	return turn
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(apiResponse); err != nil {
-		log.Printf("Error encoding response: %v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+// Summarizes the fulfillment webhook calls of a turn as "ok" or "failed",
+// or "" when no webhook was called. The failure details (webhook names and
+// error messages) are only returned with DEBUG.
+func webhookStatus(queryResult *cxpb.QueryResult) (string, string) {
+	statuses := queryResult.GetWebhookStatuses()
+	if len(statuses) == 0 {
+		return "", ""
+	}
+	names := queryResult.GetWebhookDisplayNames()
+	var failures []string
+	for i, status := range statuses {
+		if status.GetCode() == 0 {
+			continue
+		}
+		name := "webhook"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", name, status.GetMessage()))
+	}
+	if len(failures) == 0 {
+		return "ok", ""
 	}
-}
\ No newline at end of file
+	log.Printf("Warning: Fulfillment webhook failed: %s", strings.Join(failures, "; "))
+	if !appConfig.Debug {
+		return "failed", ""
+	}
+	return "failed", strings.Join(failures, "; ")
+}
+
+// Accepts absolute URIs with a host, e.g. gs://bucket/clip.wav or
+// https://example.com/clip.mp3
+func validAudioURI(uri string) bool {
+	parsed, err := url.Parse(uri)
+	return err == nil && parsed.Scheme != "" && parsed.Host != ""
+}
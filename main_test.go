@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+)
+
+const testAgentID = "11111111-2222-3333-4444-555555555555"
+
+// Replaces the global config for one test, restoring it afterwards
+func setTestConfig(t *testing.T, cfg config) {
+	t.Helper()
+	previous := appConfig
+	appConfig = cfg
+	t.Cleanup(func() { appConfig = previous })
+}
+
+// Config with the fields every detectIntent call needs
+func testDetectIntentConfig() config {
+	return config{
+		ProjectID:         "my-project",
+		LocationID:        "global",
+		DefaultAgentID:    testAgentID,
+		DialogflowTimeout: 5 * time.Second,
+	}
+}
+
+// Answers Dialogflow calls from fixture for one test
+func setTestSessionsClient(t *testing.T, fixture mockFixture) {
+	t.Helper()
+	previous := sessionsClient
+	sessionsClient = &mockSessionsClient{fixture: fixture}
+	t.Cleanup(func() { sessionsClient = previous })
+}
+
+// Sends body to detectIntentHandler and decodes the JSON reply
+func postDetectIntent(t *testing.T, body string) (*httptest.ResponseRecorder, DetectIntentResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/detectIntent", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	detectIntentHandler(rec, req)
+	var response DetectIntentResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decoding reply %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec, response
+}
+
+func TestEmptyReplyFallbackPrecedence(t *testing.T) {
+	cfg := testDetectIntentConfig()
+	cfg.DefaultEmptyResponseText = "Sorry, I have no answer."
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{}})
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"request fallbackText wins", `{"message":"hi","sessionId":"s1","fallbackText":"Lo siento."}`, "Lo siento."},
+		{"server default without fallbackText", `{"message":"hi","sessionId":"s1"}`, "Sorry, I have no answer."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, response := postDetectIntent(t, tt.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			if response.Text != tt.want || !response.WasFallback {
+				t.Errorf("text = %q, wasFallback = %v; want %q, true", response.Text, response.WasFallback, tt.want)
+			}
+		})
+	}
+}
+
+// Answers like mockSessionsClient after a delay
+type slowSessionsClient struct {
+	*mockSessionsClient
+	delay time.Duration
+}
+
+func (c slowSessionsClient) DetectIntent(ctx context.Context, req *cxpb.DetectIntentRequest, opts ...gax.CallOption) (*cxpb.DetectIntentResponse, error) {
+	time.Sleep(c.delay)
+	return c.mockSessionsClient.DetectIntent(ctx, req, opts...)
+}
+
+func TestDetectIntentOutlastsWriteTimeout(t *testing.T) {
+	cfg := testDetectIntentConfig()
+	cfg.ServerWriteTimeout = 50 * time.Millisecond
+	cfg.DialogflowTimeout = time.Second
+	setTestConfig(t, cfg)
+	previous := sessionsClient
+	sessionsClient = slowSessionsClient{
+		mockSessionsClient: &mockSessionsClient{fixture: mockFixture{Default: &mockReply{Texts: []string{"hello"}}}},
+		delay:              200 * time.Millisecond,
+	}
+	t.Cleanup(func() { sessionsClient = previous })
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(detectIntentHandler))
+	server.Config.WriteTimeout = cfg.ServerWriteTimeout
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, contentTypeJSON, strings.NewReader(`{"message":"hi","sessionId":"s1"}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var response DetectIntentResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || response.Text != "hello" {
+		t.Errorf("reply = %+v, %v; want text %q", response, err, "hello")
+	}
+}
+
+func TestDetectIntentBodyChecksum(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+
+	body := `{"message":"hi","sessionId":"s1"}`
+	sum := sha256.Sum256([]byte(body))
+	tests := []struct {
+		name      string
+		checksum  string
+		want      int
+		wantError string
+	}{
+		{"correct", hex.EncodeToString(sum[:]), http.StatusOK, ""},
+		{"correct upper-case", strings.ToUpper(hex.EncodeToString(sum[:])), http.StatusOK, ""},
+		{"incorrect", strings.Repeat("0", 64), http.StatusBadRequest, "body checksum mismatch"},
+		{"absent", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/detectIntent", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.checksum != "" {
+				req.Header.Set("X-Content-SHA256", tt.checksum)
+			}
+			rec := httptest.NewRecorder()
+			detectIntentHandler(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.wantError != "" {
+				var response errorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Error != tt.wantError {
+					t.Errorf("error = %q (%v), want %q", response.Error, err, tt.wantError)
+				}
+			}
+		})
+	}
+}
+
+func TestDialogflowEndpoint(t *testing.T) {
+	overrides := map[string]string{"us-central1": "dialogflow.example.internal:443"}
+	tests := []struct {
+		location  string
+		overrides map[string]string
+		want      string
+	}{
+		{"global", nil, "global-dialogflow.googleapis.com:443"},
+		{"europe-west1", overrides, "europe-west1-dialogflow.googleapis.com:443"},
+		{"us-central1", overrides, "dialogflow.example.internal:443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			if got := dialogflowEndpoint(tt.location, tt.overrides); got != tt.want {
+				t.Errorf("dialogflowEndpoint(%q) = %q, want %q", tt.location, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterTextsByLanguage(t *testing.T) {
+	texts := []string{"[EN] Hello", "[DE] Hallo", "[EN] Bye"}
+	tests := []struct {
+		name   string
+		format string
+		texts  []string
+		lang   string
+		want   []string
+	}{
+		{"exact language", "[%s] ", texts, "en", []string{"Hello", "Bye"}},
+		{"regional code matches base", "[%s] ", texts, "de-DE", []string{"Hallo"}},
+		{"regional prefix preferred", "[%s] ", []string{"[EN-GB] Cheers", "[EN] Hello"}, "en-GB", []string{"Cheers"}},
+		{"no match keeps all", "[%s] ", texts, "fr", texts},
+		{"format unset keeps all", "", texts, "en", texts},
+		{"unprefixed texts dropped", "[%s] ", []string{"[EN] Hello", "Untagged"}, "en", []string{"Hello"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, config{MultilangPrefixFormat: tt.format})
+			if got := filterTextsByLanguage(tt.texts, tt.lang); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterTextsByLanguage(%q, %q) = %q, want %q", tt.texts, tt.lang, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+// metrics.go
+package main
+
+import "expvar"
+
+// Process-wide counters, published in expvar format at /admin/metrics (and
+// at /debug/vars alongside the profiling endpoints with ENABLE_PPROF)
+var (
+	// Dialogflow CX detect-intent calls made on behalf of clients
+	billableUnitsTotal = expvar.NewInt("dialogflow_billable_units_total")
+
+	// Background credential refresh checks that failed, and whether the
+	// latest check succeeded (1) or failed (0)
+	credentialRefreshFailuresTotal = expvar.NewInt("credential_refresh_failures_total")
+	credentialRefreshHealthy       = expvar.NewInt("credential_refresh_healthy")
+
+	// Time spent queueing for the global Dialogflow limit
+	// (GLOBAL_DIALOGFLOW_RPS), and calls rejected because no slot freed up
+	// before the request deadline
+	dialogflowRateLimitWaitSeconds = expvar.NewFloat("dialogflow_rate_limit_wait_seconds_total")
+	dialogflowRateLimitedTotal     = expvar.NewInt("dialogflow_rate_limited_total")
+
+	// Dialogflow CX calls currently in flight (MAX_CONCURRENT_DIALOGFLOW_CALLS)
+	dialogflowConcurrentCalls = expvar.NewInt("dialogflow_cx_concurrent_calls")
+
+	// Requests per endpoint (route pattern), and those slower than
+	// SLO_LATENCY_MS; only tracked when the target is set
+	sloRequestsTotal          = expvar.NewMap("slo_requests_total")
+	sloLatencyViolationsTotal = expvar.NewMap("slo_latency_violations_total")
+
+	// Adaptive load shedding (LOAD_SHED_P95_MS): whether requests are being
+	// shed (1) or not (0), how many were rejected, and the p95 latency of
+	// the current window
+	loadShedActive        = expvar.NewInt("load_shed_active")
+	loadShedRequestsTotal = expvar.NewInt("load_shed_requests_total")
+	loadShedLatencyP95Ms  = expvar.NewInt("load_shed_latency_p95_ms")
+)
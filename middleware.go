@@ -0,0 +1,202 @@
+// middleware.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Wraps a handler with cross-cutting behavior
+type middleware func(http.Handler) http.Handler
+
+// Applies middleware to h so that the first one listed is the outermost,
+// i.e. sees the request first and the response last
+func chain(h http.Handler, stack ...middleware) http.Handler {
+	for i := len(stack) - 1; i >= 0; i-- {
+		h = stack[i](h)
+	}
+	return h
+}
+
+type contextKey string
+
+const requestIDKey contextKey = "requestID"
+
+// Longest client-supplied X-Request-ID that is reused as-is
+const maxRequestIDLength = 128
+
+// Tags each request with an ID, reusing the client's X-Request-ID when it
+// looks sane and generating a UUID otherwise. The ID is echoed back in the
+// X-Request-ID response header and available via requestIDFromContext.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, requestID)))
+	})
+}
+
+// Returns the request ID set by requestIDMiddleware, or "" outside of it
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// Sets Content-Security-Policy on every response: the configured policy for
+// HTML documents and a deny-all policy for everything else (JSON, CBOR,
+// plain text), since API responses never need to load resources
+func cspMiddleware(htmlPolicy string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hooked := &headerHookWriter{ResponseWriter: w, beforeWrite: func(h http.Header) {
+				policy := "default-src 'none'"
+				if mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type")); err == nil && mediaType == "text/html" {
+					policy = htmlPolicy
+				}
+				h.Set("Content-Security-Policy", policy)
+			}}
+			next.ServeHTTP(hooked, r)
+		})
+	}
+}
+
+// Advertises the effective timeouts (in seconds) so clients can align their
+// own: X-Server-Timeout is the server's write timeout and
+// X-Dialogflow-Timeout the per-request deadline for Dialogflow CX calls.
+// Only installed when DEBUG is set, to keep headers small.
+func timeoutHeadersMiddleware(serverTimeout, dialogflowTimeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Server-Timeout", formatSeconds(serverTimeout))
+			w.Header().Set("X-Dialogflow-Timeout", formatSeconds(dialogflowTimeout))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// Rejects request bodies with a Content-Length above maxBytes before any
+// handler decodes them, and caps bodies without a length at maxBytes while
+// read. Framing is checked by net/http before this runs: it rejects
+// malformed Content-Length values itself and ignores Content-Length when
+// Transfer-Encoding is present (RFC 7230 section 3.3.3), so such requests
+// cannot be told apart here.
+func requestBodyMiddleware(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Request body exceeds %d bytes", maxBytes))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Counts requests per endpoint and those that took longer than target, for
+// SLO burn-rate alerts. Must wrap the ServeMux directly so the matched route
+// pattern is visible after the request is served.
+func sloMiddleware(target time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			endpoint := r.Pattern
+			if endpoint == "" {
+				endpoint = "unmatched"
+			}
+			sloRequestsTotal.Add(endpoint, 1)
+			if time.Since(start) > target {
+				sloLatencyViolationsTotal.Add(endpoint, 1)
+			}
+		})
+	}
+}
+
+// Parses RESPONSE_HEADERS: "Header:value" pairs separated by "|", since
+// header values such as Cache-Control may themselves contain commas
+func parseResponseHeaders(spec string) (http.Header, error) {
+	headers := http.Header{}
+	for _, entry := range strings.Split(spec, "|") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid response header %q: expected Header:value", entry)
+		}
+		headers.Set(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// Adds the operator's RESPONSE_HEADERS to every response, leaving alone any
+// header the handler has already set (Content-Type, Cache-Control on
+// streams, ...)
+func responseHeadersMiddleware(headers http.Header) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hooked := &headerHookWriter{ResponseWriter: w, beforeWrite: func(h http.Header) {
+				for name, values := range headers {
+					if _, set := h[name]; !set {
+						h[name] = values
+					}
+				}
+			}}
+			next.ServeHTTP(hooked, r)
+		})
+	}
+}
+
+// Runs a hook just before the status line and headers are sent, so
+// middleware can set headers based on what the handler decided
+type headerHookWriter struct {
+	http.ResponseWriter
+	beforeWrite func(h http.Header)
+	wroteHeader bool
+}
+
+func (w *headerHookWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.beforeWrite(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerHookWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Keeps streaming handlers working behind the middleware
+func (w *headerHookWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		flusher.Flush()
+	}
+}
+
+func (w *headerHookWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
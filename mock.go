@@ -0,0 +1,145 @@
+// mock.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	sessionspb "cloud.google.com/go/dialogflow/cx/apiv3/cxpb"
+	"github.com/googleapis/gax-go/v2"
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The detect-intent calls the handlers make, satisfied by the real CX
+// sessions client and by the MOCK_MODE fixture client
+type sessionsAPI interface {
+	DetectIntent(ctx context.Context, req *cxpb.DetectIntentRequest, opts ...gax.CallOption) (*cxpb.DetectIntentResponse, error)
+	ServerStreamingDetectIntent(ctx context.Context, req *cxpb.DetectIntentRequest, opts ...gax.CallOption) (sessionspb.Sessions_ServerStreamingDetectIntentClient, error)
+	Close() error
+}
+
+// MOCK_MODE fixture file. Each reply matches a text input by message
+// (case-insensitive) or an event input by name; unmatched inputs get Default.
+type mockFixture struct {
+	Replies []mockReply `json:"replies"`
+	Default *mockReply  `json:"default,omitempty"`
+}
+
+type mockReply struct {
+	Message  string                   `json:"message,omitempty"`
+	Event    string                   `json:"event,omitempty"`
+	Intent   string                   `json:"intent,omitempty"` // Reported as the matched intent's display name
+	Texts    []string                 `json:"texts,omitempty"`
+	Payloads []map[string]interface{} `json:"payloads,omitempty"`
+}
+
+// Answers detect-intent calls from a fixture instead of Dialogflow CX
+type mockSessionsClient struct {
+	fixture mockFixture
+}
+
+func newMockSessionsClient(path string) (*mockSessionsClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture mockFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, reply := range fixture.Replies {
+		if (reply.Message == "") == (reply.Event == "") {
+			return nil, fmt.Errorf("reply %d must set exactly one of message or event", i)
+		}
+	}
+	return &mockSessionsClient{fixture: fixture}, nil
+}
+
+func (c *mockSessionsClient) DetectIntent(ctx context.Context, req *cxpb.DetectIntentRequest, opts ...gax.CallOption) (*cxpb.DetectIntentResponse, error) {
+	queryResult, err := c.queryResult(req)
+	if err != nil {
+		return nil, err
+	}
+	return &cxpb.DetectIntentResponse{
+		ResponseId:  "mock",
+		QueryResult: queryResult,
+	}, nil
+}
+
+func (c *mockSessionsClient) ServerStreamingDetectIntent(ctx context.Context, req *cxpb.DetectIntentRequest, opts ...gax.CallOption) (sessionspb.Sessions_ServerStreamingDetectIntentClient, error) {
+	response, err := c.DetectIntent(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	response.ResponseType = cxpb.DetectIntentResponse_FINAL
+	return &mockStream{responses: []*cxpb.DetectIntentResponse{response}}, nil
+}
+
+func (c *mockSessionsClient) Close() error {
+	return nil
+}
+
+// Builds the query result for the fixture reply matching the request
+func (c *mockSessionsClient) queryResult(req *cxpb.DetectIntentRequest) (*cxpb.QueryResult, error) {
+	queryInput := req.GetQueryInput()
+	reply := c.fixture.Default
+	for i := range c.fixture.Replies {
+		candidate := &c.fixture.Replies[i]
+		if (candidate.Message != "" && strings.EqualFold(candidate.Message, queryInput.GetText().GetText())) ||
+			(candidate.Event != "" && candidate.Event == queryInput.GetEvent().GetEvent()) {
+			reply = candidate
+			break
+		}
+	}
+
+	queryResult := &cxpb.QueryResult{LanguageCode: queryInput.GetLanguageCode()}
+	if text := queryInput.GetText(); text != nil {
+		queryResult.Query = &cxpb.QueryResult_Text{Text: text.GetText()}
+	}
+	if reply == nil {
+		return queryResult, nil
+	}
+	if reply.Intent != "" {
+		queryResult.Match = &cxpb.Match{
+			Intent:    &cxpb.Intent{DisplayName: reply.Intent},
+			MatchType: cxpb.Match_INTENT,
+		}
+	}
+	for _, text := range reply.Texts {
+		queryResult.ResponseMessages = append(queryResult.ResponseMessages, &cxpb.ResponseMessage{
+			Message: &cxpb.ResponseMessage_Text_{Text: &cxpb.ResponseMessage_Text{Text: []string{text}}},
+		})
+	}
+	for _, payload := range reply.Payloads {
+		payloadStruct, err := structpb.NewStruct(payload)
+		if err != nil {
+			return nil, fmt.Errorf("mock payload: %w", err)
+		}
+		queryResult.ResponseMessages = append(queryResult.ResponseMessages, &cxpb.ResponseMessage{
+			Message: &cxpb.ResponseMessage_Payload{Payload: payloadStruct},
+		})
+	}
+	return queryResult, nil
+}
+
+// Replays canned responses as a server stream; only Recv is used by the
+// handlers
+type mockStream struct {
+	grpc.ClientStream
+	responses []*cxpb.DetectIntentResponse
+}
+
+func (s *mockStream) Recv() (*cxpb.DetectIntentResponse, error) {
+	if len(s.responses) == 0 {
+		return nil, io.EOF
+	}
+	response := s.responses[0]
+	s.responses = s.responses[1:]
+	return response, nil
+}
@@ -0,0 +1,88 @@
+// ping.go
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+	"google.golang.org/grpc/status"
+)
+
+// Text sent to the probe agent
+const pingMessage = "ping"
+
+type pingResponse struct {
+	LatencyMs   int64  `json:"latencyMs"`
+	GRPCStatus  string `json:"grpcStatus"`
+	GRPCMessage string `json:"grpcMessage,omitempty"`
+}
+
+// Time of the last ping, to cap how often the probe agent is called
+var (
+	lastPingMu sync.Mutex
+	lastPing   time.Time
+)
+
+// Handles GET /api/debug/ping: times one detect-intent call against the
+// probe agent (PING_AGENT_ID) and reports the round trip with its gRPC
+// status, to tell network from backend slowness during incidents. Quota
+// and concurrency waits happen before the clock starts.
+func pingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if wait := reservePing(time.Now()); wait > 0 {
+		writeRetryableError(w, r, http.StatusTooManyRequests, "Ping was called too recently, retry shortly", int(math.Ceil(wait.Seconds())))
+		return
+	}
+
+	sessionPath, err := buildSessionPath(appConfig.ProjectID, appConfig.LocationID, appConfig.PingAgentID, appConfig.PingSessionID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx, cancel := dialogflowContext(w, r, appConfig.PingAgentID)
+	defer cancel()
+
+	if err := waitDialogflowQuota(ctx); err != nil {
+		writeDetectIntentError(w, r, err)
+		return
+	}
+	if err := acquireDialogflowCall(); err != nil {
+		writeDetectIntentError(w, r, err)
+		return
+	}
+	start := time.Now()
+	_, err = sessionsClient.DetectIntent(ctx, &cxpb.DetectIntentRequest{
+		Session:    sessionPath,
+		QueryInput: toCXQueryInput(QueryInput{Message: pingMessage}, "en"),
+	})
+	latency := time.Since(start)
+	releaseDialogflowCall()
+	countDetectIntentCall()
+
+	// Failed calls are still a measurement, so the status is reported
+	// rather than turned into an error response
+	s := status.Convert(err)
+	log.Printf("Ping to %s took %v: %s", sessionPath, latency, s.Code())
+	writeResponse(w, r, http.StatusOK, pingResponse{
+		LatencyMs:   latency.Milliseconds(),
+		GRPCStatus:  s.Code().String(),
+		GRPCMessage: s.Message(),
+	})
+}
+
+// Claims the next ping slot, or returns how long until one is free
+func reservePing(now time.Time) time.Duration {
+	lastPingMu.Lock()
+	defer lastPingMu.Unlock()
+	if wait := lastPing.Add(appConfig.PingMinInterval).Sub(now); wait > 0 {
+		return wait
+	}
+	lastPing = now
+	return 0
+}
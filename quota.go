@@ -0,0 +1,56 @@
+// quota.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Detect-intent calls made since the start of the current wall-clock
+// minute, to compare against the project's per-minute CX quota
+var quotaCallsThisMinute atomic.Int64
+
+// Counts one detect-intent call made on behalf of a client, for billing
+// metrics and the per-minute quota counter
+func countDetectIntentCall() {
+	billableUnitsTotal.Add(1)
+	quotaCallsThisMinute.Add(1)
+}
+
+// Resets the per-minute counter at :00 of every minute until ctx is done
+func startQuotaReset(ctx context.Context) {
+	go func() {
+		for {
+			now := time.Now()
+			timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				quotaCallsThisMinute.Store(0)
+			}
+		}
+	}()
+}
+
+type quotaResponse struct {
+	DetectIntentCallsThisMinute int64  `json:"detectIntentCallsThisMinute"`
+	QuotaLimitPerMinute         int64  `json:"quotaLimitPerMinute"`
+	Warning                     string `json:"warning,omitempty"`
+}
+
+// Handles GET /admin/quota: calls this minute against
+// DIALOGFLOW_QUOTA_LIMIT_PER_MINUTE, warning above 90% utilization
+func quotaHandler(w http.ResponseWriter, r *http.Request) {
+	response := quotaResponse{
+		DetectIntentCallsThisMinute: quotaCallsThisMinute.Load(),
+		QuotaLimitPerMinute:         appConfig.QuotaLimitPerMinute,
+	}
+	if limit := response.QuotaLimitPerMinute; limit > 0 && response.DetectIntentCallsThisMinute*10 > limit*9 {
+		response.Warning = "approaching quota limit"
+	}
+	writeResponse(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func getQuota(t *testing.T) quotaResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	quotaHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/quota", nil))
+	var response quotaResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding reply %q: %v", rec.Body.String(), err)
+	}
+	return response
+}
+
+func TestQuotaCounter(t *testing.T) {
+	cfg := testDetectIntentConfig()
+	cfg.QuotaLimitPerMinute = 10
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+	previous := quotaCallsThisMinute.Load()
+	quotaCallsThisMinute.Store(0)
+	t.Cleanup(func() { quotaCallsThisMinute.Store(previous) })
+
+	for i := 0; i < 9; i++ {
+		if rec, _ := postDetectIntent(t, `{"message":"hi","sessionId":"s1"}`); rec.Code != http.StatusOK {
+			t.Fatalf("call %d: status = %d", i+1, rec.Code)
+		}
+	}
+	if response := getQuota(t); response.DetectIntentCallsThisMinute != 9 || response.QuotaLimitPerMinute != 10 || response.Warning != "" {
+		t.Errorf("after 9 calls: %+v, want 9 of 10 and no warning", response)
+	}
+
+	postDetectIntent(t, `{"message":"hi","sessionId":"s1"}`)
+	if response := getQuota(t); response.DetectIntentCallsThisMinute != 10 || response.Warning == "" {
+		t.Errorf("after 10 calls: %+v, want 10 with a warning", response)
+	}
+}
@@ -0,0 +1,78 @@
+// ratelimit.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Reported to clients as retryable 429 and 503 errors by
+// writeDetectIntentError
+var (
+	errDialogflowRateLimited = errors.New("dialogflow rate limit reached")
+	errDialogflowBusy        = errors.New("too many concurrent dialogflow calls")
+)
+
+// Process-wide limit on detect-intent calls to Dialogflow CX
+// (GLOBAL_DIALOGFLOW_RPS), shared by every client so the project quota holds
+// regardless of where traffic comes from. Nil when unlimited.
+var dialogflowLimiter *rate.Limiter
+
+// Builds the global limiter; a burst of one second's worth of calls lets
+// short spikes through without exceeding the average rate
+func newDialogflowLimiter(rps float64) *rate.Limiter {
+	if rps <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(rps), int(math.Max(1, math.Ceil(rps))))
+}
+
+// Waits for a slot under the global limit, queueing up to the context's
+// deadline. Returns errDialogflowRateLimited when no slot frees up in time.
+func waitDialogflowQuota(ctx context.Context) error {
+	if dialogflowLimiter == nil {
+		return nil
+	}
+	start := time.Now()
+	err := dialogflowLimiter.Wait(ctx)
+	dialogflowRateLimitWaitSeconds.Add(time.Since(start).Seconds())
+	if err != nil {
+		dialogflowRateLimitedTotal.Add(1)
+		log.Printf("Global Dialogflow rate limit reached: %v", err)
+		return errDialogflowRateLimited
+	}
+	return nil
+}
+
+// Slots for in-flight Dialogflow CX calls (MAX_CONCURRENT_DIALOGFLOW_CALLS),
+// so a spike cannot exhaust gRPC connections. Nil when unlimited.
+var dialogflowCallSlots chan struct{}
+
+// Takes a call slot without waiting. Returns errDialogflowBusy when all
+// slots are taken; otherwise the caller must releaseDialogflowCall.
+func acquireDialogflowCall() error {
+	if dialogflowCallSlots == nil {
+		return nil
+	}
+	select {
+	case dialogflowCallSlots <- struct{}{}:
+		dialogflowConcurrentCalls.Add(1)
+		return nil
+	default:
+		log.Printf("Rejected request: %d concurrent Dialogflow calls in flight", cap(dialogflowCallSlots))
+		return errDialogflowBusy
+	}
+}
+
+func releaseDialogflowCall() {
+	if dialogflowCallSlots == nil {
+		return
+	}
+	dialogflowConcurrentCalls.Add(-1)
+	<-dialogflowCallSlots
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDetectIntentRejectedWhenCallSlotsFull(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+	previous := dialogflowCallSlots
+	dialogflowCallSlots = make(chan struct{}, 1)
+	t.Cleanup(func() { dialogflowCallSlots = previous })
+
+	// Hold the only slot, as a call still in flight would
+	if err := acquireDialogflowCall(); err != nil {
+		t.Fatalf("acquiring the free slot: %v", err)
+	}
+	rec, _ := postDetectIntent(t, `{"message":"hi","sessionId":"s1"}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusServiceUnavailable, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("503 has no Retry-After header")
+	}
+
+	releaseDialogflowCall()
+	rec, response := postDetectIntent(t, `{"message":"hi","sessionId":"s1"}`)
+	if rec.Code != http.StatusOK || response.Text != "hello" {
+		t.Errorf("after release: status = %d, text %q; want 200, %q", rec.Code, response.Text, "hello")
+	}
+	if len(dialogflowCallSlots) != 0 {
+		t.Errorf("%d slots still taken after the call returned", len(dialogflowCallSlots))
+	}
+}
@@ -0,0 +1,144 @@
+// replay.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+)
+
+// Upper bound on turns replayed in a single request
+const maxReplayTurns = 20
+
+// A recorded conversation to re-run, optionally against an environment
+type ReplayRequest struct {
+	AgentID       string       `json:"agentId"`
+	EnvironmentID string       `json:"environmentId"`
+	LanguageCode  string       `json:"languageCode"`
+	Turns         []ReplayTurn `json:"turns"`
+}
+
+// A recorded user message and the reply the agent gave at the time
+type ReplayTurn struct {
+	Message      string `json:"message"`
+	ExpectedText string `json:"expectedText"`
+}
+
+// Old vs new reply for one replayed turn
+type ReplayTurnResult struct {
+	Message      string `json:"message"`
+	ExpectedText string `json:"expectedText"`
+	ActualText   string `json:"actualText"`
+	Changed      bool   `json:"changed"`
+}
+
+type ReplayResponse struct {
+	SessionID string             `json:"sessionId"`
+	Turns     []ReplayTurnResult `json:"turns"`
+	Changed   int                `json:"changed"`
+}
+
+// Handles POST /api/replay: re-runs recorded turns in order on a fresh
+// session and reports, per turn, whether the agent's reply changed
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	var req ReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding replay request body: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	agentID := req.AgentID
+	if agentID == "" {
+		agentID = appConfig.DefaultAgentID
+	}
+	if agentID == "" {
+		writeError(w, r, http.StatusBadRequest, appConfig.NoAgentErrorMessage)
+		return
+	}
+	if len(req.Turns) == 0 {
+		writeError(w, r, http.StatusBadRequest, "Missing required field: turns")
+		return
+	}
+	if len(req.Turns) > maxReplayTurns {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Too many turns: at most %d are allowed", maxReplayTurns))
+		return
+	}
+	for i, turn := range req.Turns {
+		if turn.Message == "" {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Turn %d is missing a message", i))
+			return
+		}
+	}
+	langCode := req.LanguageCode
+	if langCode == "" {
+		langCode = "en"
+	}
+
+	// A fresh session keeps the replay independent of the recorded one;
+	// the UUID is shortened to fit the 36-character session ID limit
+	sessionID := "replay-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:maxSessionIDLength-len("replay-")]
+	// Draft agent, or the given environment
+	var agentPath string
+	var err error
+	if req.EnvironmentID == "" {
+		agentPath, err = buildAgentPath(appConfig.ProjectID, appConfig.LocationID, agentID)
+	} else {
+		agentPath, err = buildEnvironmentPath(appConfig.ProjectID, appConfig.LocationID, agentID, req.EnvironmentID)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	sessionPath := agentPath + "/sessions/" + sessionID
+
+	ctx, cancel := dialogflowContext(w, r, agentID)
+	defer cancel()
+
+	// Turns run sequentially so each sees the state left by the previous one
+	response := ReplayResponse{SessionID: sessionID}
+	for i, turn := range req.Turns {
+		if err := waitDialogflowQuota(ctx); err != nil {
+			writeDetectIntentError(w, r, err)
+			return
+		}
+		if err := acquireDialogflowCall(); err != nil {
+			writeDetectIntentError(w, r, err)
+			return
+		}
+		start := time.Now()
+		dialogflowResponse, err := sessionsClient.DetectIntent(ctx, &cxpb.DetectIntentRequest{
+			Session:    sessionPath,
+			QueryInput: toCXQueryInput(QueryInput{Message: turn.Message}, langCode),
+		})
+		recordDialogflowLatency(time.Since(start))
+		releaseDialogflowCall()
+		countDetectIntentCall()
+		if err != nil {
+			log.Printf("Error replaying turn %d: %v", i, err)
+			writeDialogflowError(w, r, fmt.Errorf("turn %d: %w", i, err))
+			return
+		}
+
+		actual := extractTurnResponse(dialogflowResponse.GetQueryResult())
+		result := ReplayTurnResult{
+			Message:      turn.Message,
+			ExpectedText: turn.ExpectedText,
+			ActualText:   actual.Text,
+			Changed:      actual.Text != turn.ExpectedText,
+		}
+		if result.Changed {
+			response.Changed++
+		}
+		response.Turns = append(response.Turns, result)
+	}
+
+	log.Printf("Replayed %d turns against %s: %d changed", len(req.Turns), agentPath, response.Changed)
+	writeResponse(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReplayValidatesEnvironmentID(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+
+	tests := []struct {
+		name          string
+		environmentID string
+		want          int
+	}{
+		{"draft", "", http.StatusOK},
+		{"environment", "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", http.StatusOK},
+		{"path injection", "x/sessions/other", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"environmentId":"` + tt.environmentID + `","turns":[{"message":"hi","expectedText":"hello"}]}`
+			rec := httptest.NewRecorder()
+			replayHandler(rec, httptest.NewRequest(http.MethodPost, "/api/replay", strings.NewReader(body)))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
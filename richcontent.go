@@ -0,0 +1,174 @@
+// richcontent.go
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Rich content is sent by the agent as a custom payload response message.
+// Payloads with a recognized top-level "type" are returned as typed objects;
+// anything else is passed through untouched in RichContent.Payload.
+//
+// Supported payload schema:
+//
+//	{"type": "card", "title": "...", "subtitle": "...", "imageUrl": "...", "buttons": [<button>...]}
+//	{"type": "button", "text": "...", "url": "...", "postback": "..."}
+//	{"type": "image", "url": "...", "alt": "..."}
+//	{"type": "carousel", "cards": [<card>...]}
+const (
+	richContentCard     = "card"
+	richContentButton   = "button"
+	richContentImage    = "image"
+	richContentCarousel = "carousel"
+	richContentPayload  = "payload" // Unrecognized payload, returned raw
+)
+
+// Button is a clickable action, either opening a URL or sending a postback message
+type Button struct {
+	Text     string `json:"text"`
+	URL      string `json:"url,omitempty"`
+	Postback string `json:"postback,omitempty"`
+}
+
+// Card is a titled block with optional image and buttons
+type Card struct {
+	Title    string   `json:"title"`
+	Subtitle string   `json:"subtitle,omitempty"`
+	ImageURL string   `json:"imageUrl,omitempty"`
+	Buttons  []Button `json:"buttons,omitempty"`
+}
+
+// Image is a standalone picture
+type Image struct {
+	URL string `json:"url"`
+	Alt string `json:"alt,omitempty"`
+}
+
+// Carousel is a horizontally scrollable list of cards
+type Carousel struct {
+	Cards []Card `json:"cards"`
+}
+
+// RichContent holds exactly one of the typed fields, selected by Type
+type RichContent struct {
+	Type     string                 `json:"type"`
+	Card     *Card                  `json:"card,omitempty"`
+	Button   *Button                `json:"button,omitempty"`
+	Image    *Image                 `json:"image,omitempty"`
+	Carousel *Carousel              `json:"carousel,omitempty"`
+	Payload  map[string]interface{} `json:"payload,omitempty"`
+}
+
+// Parses a custom payload into typed rich content, falling back to the raw
+// payload when the type is unknown or the required fields are missing
+func parseRichContent(payload *structpb.Struct) RichContent {
+	raw := payload.AsMap()
+	fallback := RichContent{
+		Type:    richContentPayload,
+		Payload: filterPayloadKeys(raw, appConfig.PayloadKeyAllowlist, appConfig.PayloadKeyAllowlistRecursive),
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return fallback
+	}
+
+	contentType, _ := raw["type"].(string)
+	switch contentType {
+	case richContentCard:
+		var card Card
+		if json.Unmarshal(data, &card) != nil || card.Title == "" {
+			return fallback
+		}
+		return RichContent{Type: richContentCard, Card: &card}
+	case richContentButton:
+		var button Button
+		if json.Unmarshal(data, &button) != nil || button.Text == "" {
+			return fallback
+		}
+		return RichContent{Type: richContentButton, Button: &button}
+	case richContentImage:
+		var image Image
+		if json.Unmarshal(data, &image) != nil || image.URL == "" {
+			return fallback
+		}
+		return RichContent{Type: richContentImage, Image: &image}
+	case richContentCarousel:
+		var carousel Carousel
+		if json.Unmarshal(data, &carousel) != nil || len(carousel.Cards) == 0 {
+			return fallback
+		}
+		return RichContent{Type: richContentCarousel, Carousel: &carousel}
+	}
+	return fallback
+}
+
+// Builds a plain-text stand-in for turns that return only rich content, from
+// card titles, button labels and image alt text. Raw payloads are skipped.
+func summarizeRichContent(items []RichContent) string {
+	var lines []string
+	for _, item := range items {
+		switch item.Type {
+		case richContentCard:
+			lines = append(lines, item.Card.Title)
+		case richContentButton:
+			lines = append(lines, item.Button.Text)
+		case richContentImage:
+			if item.Image.Alt != "" {
+				lines = append(lines, item.Image.Alt)
+			}
+		case richContentCarousel:
+			titles := make([]string, len(item.Carousel.Cards))
+			for i, card := range item.Carousel.Cards {
+				titles[i] = card.Title
+			}
+			lines = append(lines, strings.Join(titles, ", "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Drops payload keys that are not in the allowlist. An empty allowlist keeps
+// everything. Only top-level keys are filtered unless recursive is set, in
+// which case nested objects (including those inside arrays) are filtered too.
+func filterPayloadKeys(payload map[string]interface{}, allowlist []string, recursive bool) map[string]interface{} {
+	if len(allowlist) == 0 {
+		return payload
+	}
+	allowed := make(map[string]bool, len(allowlist))
+	for _, key := range allowlist {
+		allowed[key] = true
+	}
+	return filterMapKeys(payload, allowed, recursive)
+}
+
+func filterMapKeys(m map[string]interface{}, allowed map[string]bool, recursive bool) map[string]interface{} {
+	filtered := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		if !allowed[key] {
+			continue
+		}
+		if recursive {
+			value = filterNestedKeys(value, allowed)
+		}
+		filtered[key] = value
+	}
+	return filtered
+}
+
+func filterNestedKeys(value interface{}, allowed map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return filterMapKeys(v, allowed, true)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = filterNestedKeys(item, allowed)
+		}
+		return items
+	}
+	return value
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestParseRichContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		want    RichContent
+	}{
+		{
+			name: "card",
+			payload: map[string]interface{}{
+				"type": "card", "title": "Pizza", "subtitle": "Large", "imageUrl": "https://example.com/pizza.png",
+				"buttons": []interface{}{map[string]interface{}{"text": "Order", "postback": "order pizza"}},
+			},
+			want: RichContent{Type: richContentCard, Card: &Card{
+				Title: "Pizza", Subtitle: "Large", ImageURL: "https://example.com/pizza.png",
+				Buttons: []Button{{Text: "Order", Postback: "order pizza"}},
+			}},
+		},
+		{
+			name:    "button",
+			payload: map[string]interface{}{"type": "button", "text": "Docs", "url": "https://example.com/docs"},
+			want:    RichContent{Type: richContentButton, Button: &Button{Text: "Docs", URL: "https://example.com/docs"}},
+		},
+		{
+			name:    "image",
+			payload: map[string]interface{}{"type": "image", "url": "https://example.com/map.png", "alt": "Map"},
+			want:    RichContent{Type: richContentImage, Image: &Image{URL: "https://example.com/map.png", Alt: "Map"}},
+		},
+		{
+			name: "carousel",
+			payload: map[string]interface{}{"type": "carousel", "cards": []interface{}{
+				map[string]interface{}{"title": "One"},
+				map[string]interface{}{"title": "Two"},
+			}},
+			want: RichContent{Type: richContentCarousel, Carousel: &Carousel{Cards: []Card{{Title: "One"}, {Title: "Two"}}}},
+		},
+		{
+			name:    "card without title",
+			payload: map[string]interface{}{"type": "card", "subtitle": "Large"},
+			want:    RichContent{Type: richContentPayload, Payload: map[string]interface{}{"type": "card", "subtitle": "Large"}},
+		},
+		{
+			name:    "unknown type",
+			payload: map[string]interface{}{"type": "quickReplies", "options": []interface{}{"yes", "no"}},
+			want:    RichContent{Type: richContentPayload, Payload: map[string]interface{}{"type": "quickReplies", "options": []interface{}{"yes", "no"}}},
+		},
+		{
+			name:    "no type",
+			payload: map[string]interface{}{"richContent": true},
+			want:    RichContent{Type: richContentPayload, Payload: map[string]interface{}{"richContent": true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, config{})
+			payload, err := structpb.NewStruct(tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := parseRichContent(payload); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRichContent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeRichContent(t *testing.T) {
+	items := []RichContent{
+		{Type: richContentCard, Card: &Card{Title: "Pizza"}},
+		{Type: richContentButton, Button: &Button{Text: "Order"}},
+		{Type: richContentImage, Image: &Image{URL: "https://example.com/a.png"}},
+		{Type: richContentCarousel, Carousel: &Carousel{Cards: []Card{{Title: "One"}, {Title: "Two"}}}},
+		{Type: richContentPayload, Payload: map[string]interface{}{"x": 1.0}},
+	}
+	if got, want := summarizeRichContent(items), "Pizza\nOrder\nOne, Two"; got != want {
+		t.Errorf("summarizeRichContent() = %q, want %q", got, want)
+	}
+}
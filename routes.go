@@ -0,0 +1,80 @@
+// routes.go
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Registers handler for pattern, accepting only the given methods. The
+// method check runs before the handler and anything wrapped around it
+// (auth, load shedding), so OPTIONS probes from gateways and other non-CORS
+// clients get 204 with an Allow header on every route, protected or not,
+// and other methods get a 405.
+func handleMethods(mux *http.ServeMux, pattern string, handler http.Handler, methods ...string) {
+	mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checkMethod(w, r, methods...) {
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// Registers every route enabled by appConfig. Routes are registered with
+// their methods so OPTIONS and 405s are answered the same way everywhere,
+// before auth or load shedding (shedLoad, applied to the client-facing
+// Dialogflow endpoints).
+func newRouter(shedLoad middleware) *http.ServeMux {
+	mux := http.NewServeMux()
+	handleMethods(mux, "/api/dialogflow/detectIntent", shedLoad(http.HandlerFunc(detectIntentHandler)), detectIntentMethods()...)
+	handleMethods(mux, "/api/dialogflow/stream-ndjson", shedLoad(http.HandlerFunc(streamNDJSONHandler)), http.MethodPost)
+
+	if len(appConfig.WebhookForwardURLs) > 0 {
+		// Only the agent, configured to send the key, may relay calls upstream
+		webhookAuth := AuthMiddleware(appConfig.WebhookForwardKey)
+		handleMethods(mux, "/webhook/forward", webhookAuth(http.HandlerFunc(webhookForwardHandler)), http.MethodPost)
+		log.Printf("Webhook forwarding enabled to %d upstreams", len(appConfig.WebhookForwardURLs))
+	}
+
+	// --- Channel Webhooks ---
+	if appConfig.LineChannelSecret != "" {
+		handleMethods(mux, "/webhook/line", http.HandlerFunc(lineWebhookHandler), http.MethodPost)
+		log.Printf("LINE webhook enabled at /webhook/line")
+	}
+	if appConfig.TeamsAppID != "" {
+		handleMethods(mux, "/webhook/teams", http.HandlerFunc(teamsWebhookHandler), http.MethodPost)
+		log.Printf("Teams webhook enabled at /webhook/teams")
+	}
+
+	// --- Protected API (API_KEY) ---
+	apiAuth := AuthMiddleware(appConfig.APIKey)
+	if appConfig.MockFixture == "" {
+		handleMethods(mux, "/api/dialogflow/agents/{agentId}/intents/{displayName}", apiAuth(http.HandlerFunc(intentDetailHandler)), http.MethodGet)
+	}
+	handleMethods(mux, "/api/replay", apiAuth(shedLoad(http.HandlerFunc(replayHandler))), http.MethodPost)
+	handleMethods(mux, "/api/dialogflow/test-agent", apiAuth(http.HandlerFunc(testAgentHandler)), http.MethodGet)
+	if appConfig.PingAgentID != "" {
+		handleMethods(mux, "/api/debug/ping", apiAuth(http.HandlerFunc(pingHandler)), http.MethodGet)
+	}
+	handleMethods(mux, "/admin/agentPool/drain", apiAuth(http.HandlerFunc(drainAgentHandler)), http.MethodPost)
+	handleMethods(mux, "/admin/agentPool/undrain", apiAuth(http.HandlerFunc(undrainAgentHandler)), http.MethodPost)
+	handleMethods(mux, "/admin/quota", apiAuth(http.HandlerFunc(quotaHandler)), http.MethodGet)
+	handleMethods(mux, "/admin/metrics", apiAuth(expvar.Handler()), http.MethodGet)
+	handleMethods(mux, "/healthz", http.HandlerFunc(healthCheckHandler), http.MethodGet, http.MethodHead)
+	handleMethods(mux, "/api/health", http.HandlerFunc(apiHealthHandler), http.MethodGet)
+
+	// --- Profiling (opt-in, protected by its own key) ---
+	if appConfig.EnablePprof {
+		pprofAuth := AuthMiddleware(appConfig.PprofAPIKey)
+		handleMethods(mux, "/debug/pprof/", pprofAuth(http.HandlerFunc(pprof.Index)), http.MethodGet)
+		handleMethods(mux, "/debug/pprof/cmdline", pprofAuth(http.HandlerFunc(pprof.Cmdline)), http.MethodGet)
+		handleMethods(mux, "/debug/pprof/profile", pprofAuth(http.HandlerFunc(pprof.Profile)), http.MethodGet)
+		handleMethods(mux, "/debug/pprof/symbol", pprofAuth(http.HandlerFunc(pprof.Symbol)), http.MethodGet, http.MethodPost)
+		handleMethods(mux, "/debug/pprof/trace", pprofAuth(http.HandlerFunc(pprof.Trace)), http.MethodGet)
+		handleMethods(mux, "/debug/vars", pprofAuth(expvar.Handler()), http.MethodGet)
+		log.Printf("pprof endpoints enabled under /debug/pprof/")
+	}
+	return mux
+}
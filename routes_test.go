@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleMethods(t *testing.T) {
+	setTestConfig(t, config{})
+	mux := http.NewServeMux()
+	handleMethods(mux, "/thing", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), http.MethodPost)
+
+	tests := []struct {
+		method    string
+		want      int
+		wantAllow string
+	}{
+		{http.MethodPost, http.StatusOK, ""},
+		{http.MethodGet, http.StatusMethodNotAllowed, "POST, OPTIONS"},
+		{http.MethodOptions, http.StatusNoContent, "POST, OPTIONS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, "/thing", nil))
+			if rec.Code != tt.want || rec.Header().Get("Allow") != tt.wantAllow {
+				t.Errorf("status = %d, Allow %q; want %d, %q", rec.Code, rec.Header().Get("Allow"), tt.want, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestMetricsRouteWithoutPprof(t *testing.T) {
+	setTestConfig(t, config{APIKey: "secret-key"})
+	mux := newRouter(func(h http.Handler) http.Handler { return h })
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"with key", "Bearer secret-key", http.StatusOK},
+		{"without key", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(rec.Body.String(), "dialogflow_billable_units_total") {
+				t.Errorf("body does not report dialogflow_billable_units_total: %.200s", rec.Body.String())
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("/debug/vars status = %d, want %d with pprof disabled", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestRouterRejectsUnregisteredMethods(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+	mux := newRouter(func(h http.Handler) http.Handler { return h })
+
+	tests := []struct {
+		method    string
+		want      int
+		wantAllow string
+	}{
+		{http.MethodPost, http.StatusOK, ""},
+		{http.MethodDelete, http.StatusMethodNotAllowed, "POST, OPTIONS"},
+		{http.MethodPut, http.StatusMethodNotAllowed, "POST, OPTIONS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/dialogflow/detectIntent", strings.NewReader(`{"message":"hi","sessionId":"s1"}`))
+			req.Header.Set("Content-Type", contentTypeJSON)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want || rec.Header().Get("Allow") != tt.wantAllow {
+				t.Errorf("status = %d, Allow %q; want %d, %q", rec.Code, rec.Header().Get("Allow"), tt.want, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestPprofRoutes(t *testing.T) {
+	tests := []struct {
+		name          string
+		enabled       bool
+		authorization string
+		want          int
+	}{
+		{"disabled", false, "Bearer pprof-key", http.StatusNotFound},
+		{"enabled without key", true, "", http.StatusUnauthorized},
+		{"enabled with API key", true, "Bearer api-key", http.StatusUnauthorized},
+		{"enabled with pprof key", true, "Bearer pprof-key", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, config{EnablePprof: tt.enabled, PprofAPIKey: "pprof-key", APIKey: "api-key"})
+			mux := newRouter(func(h http.Handler) http.Handler { return h })
+			for _, path := range []string{"/debug/pprof/", "/debug/vars"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				if tt.authorization != "" {
+					req.Header.Set("Authorization", tt.authorization)
+				}
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, req)
+				if rec.Code != tt.want {
+					t.Errorf("%s: status = %d, want %d", path, rec.Code, tt.want)
+				}
+			}
+		})
+	}
+}
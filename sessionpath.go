@@ -0,0 +1,69 @@
+// sessionpath.go
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// CX limits session IDs to 36 characters
+const maxSessionIDLength = 36
+
+var (
+	// GCP project IDs, optionally domain-scoped (example.com:my-project)
+	projectIDPattern = regexp.MustCompile(`^([a-z0-9.-]+:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+	// "global", a multi-region such as "us", or a region such as "us-central1"
+	locationIDPattern = regexp.MustCompile(`^[a-z]+(-[a-z]+[0-9]+)?$`)
+
+	// CX agent IDs are UUIDs
+	agentIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+	// So are environment IDs
+	environmentIDPattern = agentIDPattern
+
+	// Anything that cannot be misread as another path segment or query
+	sessionIDPattern = regexp.MustCompile(`^[^/?#\s]+$`)
+)
+
+// Builds projects/.../locations/.../agents/... after checking each
+// component, so a typo fails with an error naming it instead of an
+// InvalidArgument from Dialogflow. Errors are safe to return to clients.
+func buildAgentPath(projectID, locationID, agentID string) (string, error) {
+	if !projectIDPattern.MatchString(projectID) {
+		return "", fmt.Errorf("Invalid DIALOGFLOW_PROJECT_ID %q on the server: expected a GCP project ID", projectID)
+	}
+	if !locationIDPattern.MatchString(locationID) {
+		return "", fmt.Errorf("Invalid DIALOGFLOW_LOCATION_ID %q on the server: expected global or a region such as us-central1", locationID)
+	}
+	if !agentIDPattern.MatchString(agentID) {
+		return "", fmt.Errorf("Invalid agentId %q: expected a Dialogflow CX agent UUID", agentID)
+	}
+	return fmt.Sprintf("projects/%s/locations/%s/agents/%s", projectID, locationID, agentID), nil
+}
+
+// Builds the path of one of the agent's environments, checking the
+// environment ID as well
+func buildEnvironmentPath(projectID, locationID, agentID, environmentID string) (string, error) {
+	agentPath, err := buildAgentPath(projectID, locationID, agentID)
+	if err != nil {
+		return "", err
+	}
+	if !environmentIDPattern.MatchString(environmentID) {
+		return "", fmt.Errorf("Invalid environmentId %q: expected a Dialogflow CX environment UUID", environmentID)
+	}
+	return agentPath + "/environments/" + environmentID, nil
+}
+
+// Builds the agent's session path, checking the session ID as well
+func buildSessionPath(projectID, locationID, agentID, sessionID string) (string, error) {
+	agentPath, err := buildAgentPath(projectID, locationID, agentID)
+	if err != nil {
+		return "", err
+	}
+	if utf8.RuneCountInString(sessionID) > maxSessionIDLength || !sessionIDPattern.MatchString(sessionID) {
+		return "", fmt.Errorf("Invalid sessionId: expected at most %d characters without /, ?, # or whitespace", maxSessionIDLength)
+	}
+	return agentPath + "/sessions/" + sessionID, nil
+}
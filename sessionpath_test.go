@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildSessionPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		projectID string
+		location  string
+		agentID   string
+		sessionID string
+		want      string
+		wantErr   string // substring of the error
+	}{
+		{"valid", "my-project", "global", testAgentID, "s1", "projects/my-project/locations/global/agents/" + testAgentID + "/sessions/s1", ""},
+		{"domain-scoped project", "example.com:my-project", "us-central1", testAgentID, "s1", "projects/example.com:my-project/locations/us-central1/agents/" + testAgentID + "/sessions/s1", ""},
+		{"uppercase project", "My-Project", "global", testAgentID, "s1", "", "DIALOGFLOW_PROJECT_ID"},
+		{"bad location", "my-project", "us central1", testAgentID, "s1", "", "DIALOGFLOW_LOCATION_ID"},
+		{"agent not a UUID", "my-project", "global", "my-agent", "s1", "", "agentId"},
+		{"agent with path", "my-project", "global", testAgentID + "/intents", "s1", "", "agentId"},
+		{"empty session", "my-project", "global", testAgentID, "", "", "sessionId"},
+		{"session with slash", "my-project", "global", testAgentID, "a/b", "", "sessionId"},
+		{"session with space", "my-project", "global", testAgentID, "a b", "", "sessionId"},
+		{"session too long", "my-project", "global", testAgentID, strings.Repeat("s", maxSessionIDLength+1), "", "sessionId"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildSessionPath(tt.projectID, tt.location, tt.agentID, tt.sessionID)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("buildSessionPath() error = %v, want one naming %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("buildSessionPath() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
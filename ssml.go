@@ -0,0 +1,48 @@
+// ssml.go
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// Reports whether agent text looks like SSML rather than display text
+func containsSSML(text string) bool {
+	return strings.Contains(strings.ToLower(text), "<speak")
+}
+
+// SSML elements that separate words, so they become spaces when stripped;
+// inline ones such as <emphasis> or <say-as> are removed without a gap
+var ssmlBreakTags = map[string]bool{"speak": true, "break": true, "p": true, "s": true}
+
+// Returns the text a chat bubble should show for SSML: tags removed,
+// entities decoded and whitespace collapsed
+func stripSSML(ssml string) string {
+	var text, tag strings.Builder
+	inTag := false
+	for _, r := range ssml {
+		switch {
+		case r == '<':
+			inTag = true
+			tag.Reset()
+		case r == '>' && inTag:
+			inTag = false
+			if fields := strings.Fields(strings.Trim(tag.String(), "/")); len(fields) > 0 && ssmlBreakTags[strings.ToLower(fields[0])] {
+				text.WriteByte(' ')
+			}
+		case inTag:
+			tag.WriteRune(r)
+		default:
+			text.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(text.String())), " ")
+}
+
+// Text to show in chat: the SSML-stripped text when the reply is SSML
+func (r DetectIntentResponse) displayText() string {
+	if r.SpeakText != "" {
+		return r.SpeakText
+	}
+	return r.Text
+}
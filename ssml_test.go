@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStripSSML(t *testing.T) {
+	tests := []struct {
+		name string
+		ssml string
+		want string
+	}{
+		{"plain text", "Hello there", "Hello there"},
+		{"speak", "<speak>Hello there</speak>", "Hello there"},
+		{"break between words", `<speak>Hello<break time="1s"/>there</speak>`, "Hello there"},
+		{"sentences", "<speak><s>One.</s><s>Two.</s></speak>", "One. Two."},
+		{"inline tags", `<speak>Call <say-as interpret-as="telephone">555</say-as> <emphasis>now</emphasis></speak>`, "Call 555 now"},
+		{"entities", "<speak>Fish &amp; chips</speak>", "Fish & chips"},
+		{"whitespace", "<speak>\n  Hello\n  there\n</speak>", "Hello there"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripSSML(tt.ssml); got != tt.want {
+				t.Errorf("stripSSML(%q) = %q, want %q", tt.ssml, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSMLReplyFields(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestSessionsClient(t, mockFixture{Replies: []mockReply{
+		{Message: "plain", Texts: []string{"Hello there"}},
+		{Message: "ssml", Texts: []string{"<speak>Hello<break/>there</speak>"}},
+	}})
+
+	tests := []struct {
+		message       string
+		wantText      string
+		wantSSML      string
+		wantSpeakText string
+	}{
+		{"plain", "Hello there", "", ""},
+		{"ssml", "<speak>Hello<break/>there</speak>", "<speak>Hello<break/>there</speak>", "Hello there"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			rec, response := postDetectIntent(t, `{"message":"`+tt.message+`","sessionId":"s1"}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if response.Text != tt.wantText || response.SSML != tt.wantSSML || response.SpeakText != tt.wantSpeakText {
+				t.Errorf("text %q, ssml %q, speakText %q; want %q, %q, %q",
+					response.Text, response.SSML, response.SpeakText, tt.wantText, tt.wantSSML, tt.wantSpeakText)
+			}
+		})
+	}
+}
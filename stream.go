@@ -0,0 +1,316 @@
+// stream.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+)
+
+// One line of an NDJSON stream. Message lines carry a single response
+// message; the stream always ends with exactly one status line.
+type streamLine struct {
+	Type        string       `json:"type"` // "message" or "status"
+	Partial     bool         `json:"partial,omitempty"`
+	Text        string       `json:"text,omitempty"`
+	SpeakText   string       `json:"speakText,omitempty"`
+	SSML        string       `json:"ssml,omitempty"`
+	WasFallback bool         `json:"wasFallback,omitempty"`
+	RichContent *RichContent `json:"richContent,omitempty"`
+	Status      string       `json:"status,omitempty"` // "ok" or "error"
+	Error       string       `json:"error,omitempty"`
+	Category    string       `json:"category,omitempty"` // Set with Error
+	SessionID   string       `json:"sessionId,omitempty"`
+}
+
+// Handles requests to /api/dialogflow/stream-ndjson: runs the message through
+// the server-streaming detect-intent API and writes each response message as
+// a JSON line as soon as Dialogflow CX produces it
+func streamNDJSONHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := readDetectIntentRequest(w, r)
+	if !ok {
+		return
+	}
+	applySessionCookie(w, r, &req)
+	call, err := prepareDetectIntent(req)
+	if err != nil {
+		log.Printf("Validation Error: %v", err)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if isDraining(call.agentID) {
+		log.Printf("Rejected request for draining agent %s", call.agentID)
+		writeRetryableError(w, r, http.StatusServiceUnavailable, "Agent is draining, retry shortly", drainRetryAfterSeconds)
+		return
+	}
+
+	streamDetectIntent(w, r, call, false)
+}
+
+// Runs a single-input call through the server-streaming detect-intent API,
+// writing each response message as it arrives, either as NDJSON lines or as
+// Server-Sent Events (event name = line type, data = the JSON line). Lines
+// get the same text processing as the single reply (see messageLines);
+// entities, audio URIs and the webhook status are not streamed.
+func streamDetectIntent(w http.ResponseWriter, r *http.Request, call *detectIntentCall, sse bool) {
+	if len(call.inputs) != 1 {
+		writeError(w, r, http.StatusBadRequest, "Streaming supports a single input")
+		return
+	}
+	if call.raw {
+		writeError(w, r, http.StatusBadRequest, "raw is not available when streaming")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	// The request context is canceled when the client disconnects, which
+	// also cancels the upstream stream
+	ctx, cancel := dialogflowContext(w, r, call.agentID)
+	defer cancel()
+
+	input := call.inputs[0]
+	log.Printf("Streaming CX request to Dialogflow: Path=%s, Lang=%s, Message=%q, Event=%q",
+		call.sessionPath, call.langCode, input.Message, input.Event)
+
+	if err := waitDialogflowQuota(ctx); err != nil {
+		writeDetectIntentError(w, r, err)
+		return
+	}
+
+	// The slot is held until the stream ends
+	if err := acquireDialogflowCall(); err != nil {
+		writeDetectIntentError(w, r, err)
+		return
+	}
+	defer releaseDialogflowCall()
+
+	stream, err := sessionsClient.ServerStreamingDetectIntent(ctx, call.dialogflowRequest(input))
+	countDetectIntentCall()
+	if err != nil {
+		log.Printf("Error calling Dialogflow CX ServerStreamingDetectIntent: %v", err)
+		writeDialogflowError(w, r, err)
+		return
+	}
+
+	if sse {
+		w.Header().Set("Content-Type", contentTypeEventStream)
+	} else {
+		w.Header().Set("Content-Type", contentTypeNDJSON)
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	writeLine := func(line streamLine) bool {
+		data, err := json.Marshal(line)
+		if err == nil {
+			if sse {
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", line.Type, data)
+			} else {
+				_, err = fmt.Fprintf(w, "%s\n", data)
+			}
+		}
+		if err != nil {
+			log.Printf("Client disconnected from stream: %v", err)
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	// Without any text the stream ends with the text the single reply
+	// would have used instead
+	sentText := false
+	var richContent []RichContent
+	for {
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Stream canceled: %v", ctx.Err())
+				return
+			}
+			log.Printf("Error receiving from Dialogflow CX stream: %v", err)
+			writeLine(streamLine{Type: "status", Status: "error", Error: fmt.Sprintf("Dialogflow CX API error: %v", err), Category: dialogflowErrorCategory(err), SessionID: call.sessionID})
+			return
+		}
+
+		partial := response.GetResponseType() == cxpb.DetectIntentResponse_PARTIAL
+		queryResult := response.GetQueryResult()
+		lang := queryResult.GetLanguageCode()
+		if lang == "" {
+			lang = call.langCode
+		}
+		for _, line := range call.messageLines(filterMessagesByChannel(queryResult.GetResponseMessages(), call.channel), lang) {
+			if line.RichContent != nil {
+				richContent = append(richContent, *line.RichContent)
+			} else {
+				sentText = true
+			}
+			line.Partial = partial
+			if !writeLine(line) {
+				return
+			}
+		}
+	}
+	if !sentText {
+		if line, ok := call.noTextLine(richContent); ok && !writeLine(line) {
+			return
+		}
+	}
+
+	writeLine(streamLine{Type: "status", Status: "ok", SessionID: call.sessionID})
+}
+
+// Converts a text or custom payload response message into a stream line;
+// other message types are skipped
+func streamMessageLine(message *cxpb.ResponseMessage) (streamLine, bool) {
+	if textMessage := message.GetText(); textMessage != nil {
+		texts := textMessage.GetText()
+		if len(texts) == 0 {
+			return streamLine{}, false
+		}
+		return streamLine{Type: "message", Text: texts[0]}, true
+	}
+	if payload := message.GetPayload(); payload != nil {
+		content := parseRichContent(payload)
+		return streamLine{Type: "message", RichContent: &content}, true
+	}
+	return streamLine{}, false
+}
+
+// Converts the response messages of a turn into message lines, processed
+// like the single reply: with MULTILANG_PREFIX_FORMAT only the texts in the
+// turn's language are kept (prefix stripped), and text lines go through
+// textLine
+func (c *detectIntentCall) messageLines(messages []*cxpb.ResponseMessage, lang string) []streamLine {
+	var texts []string
+	for _, message := range messages {
+		if textMessage := message.GetText(); textMessage != nil && len(textMessage.GetText()) > 0 {
+			texts = append(texts, textMessage.GetText()[0])
+		}
+	}
+	prefix := languagePrefix(texts, lang)
+
+	var lines []streamLine
+	for _, message := range messages {
+		line, ok := streamMessageLine(message)
+		if !ok {
+			continue
+		}
+		if line.RichContent == nil {
+			text, inLanguage := strings.CutPrefix(line.Text, prefix)
+			if !inLanguage {
+				continue
+			}
+			line = c.textLine(text)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Builds a message line for reply text: filtered, and with ssml and
+// speakText set when it contains SSML
+func (c *detectIntentCall) textLine(text string) streamLine {
+	line := streamLine{Type: "message", Text: c.filterText(text)}
+	if containsSSML(line.Text) {
+		line.SSML, line.SpeakText = line.Text, stripSSML(line.Text)
+	}
+	return line
+}
+
+// Returns the line for a reply without text: the SYNTHESIZE_PAYLOAD_TEXT
+// summary of its rich content, else the fallback text (wasFallback), as in
+// the single reply. False when there is nothing to send.
+func (c *detectIntentCall) noTextLine(richContent []RichContent) (streamLine, bool) {
+	text := ""
+	if appConfig.SynthesizePayloadText {
+		text = summarizeRichContent(richContent)
+	}
+	fallback := text == ""
+	if fallback {
+		text = c.emptyReplyText()
+	}
+	if text == "" {
+		return streamLine{}, false
+	}
+	line := c.textLine(text)
+	line.WasFallback = fallback
+	return line, true
+}
+
+// Returns a turn's reply as message lines, with the line for a reply
+// without text (see noTextLine) when none of them has text
+func (c *detectIntentCall) replyLines(queryResult *cxpb.QueryResult) []streamLine {
+	lang := queryResult.GetLanguageCode()
+	if lang == "" {
+		lang = c.langCode
+	}
+	lines := c.messageLines(queryResult.GetResponseMessages(), lang)
+	var richContent []RichContent
+	for _, line := range lines {
+		if line.RichContent == nil {
+			return lines
+		}
+		richContent = append(richContent, *line.RichContent)
+	}
+	if line, ok := c.noTextLine(richContent); ok {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Reports whether a reply is large enough to send part by part: more than
+// STREAMING_RESPONSE_MIN_PARTS text messages, or more than one payload
+func manyResponseParts(parts []streamLine) bool {
+	texts, payloads := 0, 0
+	for _, part := range parts {
+		if part.RichContent != nil {
+			payloads++
+		} else {
+			texts++
+		}
+	}
+	return texts > appConfig.StreamingResponseMinParts || payloads > 1
+}
+
+// Writes a finished detect-intent reply as NDJSON, one response message per
+// line and flushed as it is written so clients can render each part right
+// away, ending with the same status line as the streaming endpoint. The
+// parts are processed like streamed lines (see replyLines).
+func writeResponseParts(w http.ResponseWriter, r *http.Request, response DetectIntentResponse) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeResponse(w, r, http.StatusOK, response)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, line := range response.parts {
+		if err := encoder.Encode(line); err != nil {
+			log.Printf("Client disconnected from response parts: %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+	encoder.Encode(streamLine{Type: "status", Status: "ok", SessionID: response.SessionID})
+	flusher.Flush()
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Decodes every line of an NDJSON reply
+func decodeStreamLines(t *testing.T, body string) []streamLine {
+	t.Helper()
+	var lines []streamLine
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var line streamLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("decoding line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestStreamNDJSONAppliesResponseFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		body string
+		want string
+	}{
+		{"mask", filterModeMask, `{"message":"hi","sessionId":"s1"}`, "well **** it"},
+		{"block with fallbackText", filterModeBlock, `{"message":"hi","sessionId":"s1","fallbackText":"Lo siento."}`, "Lo siento."},
+		{"block without fallbackText", filterModeBlock, `{"message":"hi","sessionId":"s1"}`, "Blocked."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newWordFilter([]string{"darn"}, "", tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := testDetectIntentConfig()
+			cfg.ResponseFilter = filter
+			cfg.ResponseFilterFallbackText = "Blocked."
+			setTestConfig(t, cfg)
+			setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"well darn it"}}})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/stream-ndjson", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			streamNDJSONHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+
+			lines := decodeStreamLines(t, rec.Body.String())
+			if len(lines) != 2 || lines[0].Type != "message" || lines[1].Status != "ok" {
+				t.Fatalf("lines = %+v, want one message and an ok status", lines)
+			}
+			if lines[0].Text != tt.want {
+				t.Errorf("text = %q, want %q", lines[0].Text, tt.want)
+			}
+		})
+	}
+}
+
+// Decodes the data of every event of an SSE reply
+func decodeSSELines(t *testing.T, body string) []streamLine {
+	t.Helper()
+	var lines []streamLine
+	for _, event := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		_, data, ok := strings.Cut(event, "\ndata: ")
+		if !ok {
+			t.Fatalf("malformed event %q", event)
+		}
+		var line streamLine
+		if err := json.Unmarshal([]byte(data), &line); err != nil {
+			t.Fatalf("decoding event %q: %v", event, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func postDetectIntentSSE(t *testing.T, body string) []streamLine {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/detectIntent", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", contentTypeEventStream)
+	rec := httptest.NewRecorder()
+	detectIntentHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	return decodeSSELines(t, rec.Body.String())
+}
+
+func TestDetectIntentSSEMatchesSingleReply(t *testing.T) {
+	filter, err := newWordFilter([]string{"darn"}, "", filterModeMask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testDetectIntentConfig()
+	cfg.ResponseFilter = filter
+	cfg.MultilangPrefixFormat = "[%s] "
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Replies: []mockReply{{
+		Message: "hi",
+		Texts:   []string{"[DE] Verflixt", "[EN] <speak>Oh darn</speak>"},
+	}}})
+
+	body := `{"message":"hi","sessionId":"s1","stream":true}`
+	lines := postDetectIntentSSE(t, body)
+	_, single := postDetectIntent(t, body)
+
+	if len(lines) != 2 || lines[1].Status != "ok" {
+		t.Fatalf("lines = %+v, want one message and an ok status", lines)
+	}
+	got := lines[0]
+	if got.Text != single.Text || got.SSML != single.SSML || got.SpeakText != single.SpeakText {
+		t.Errorf("streamed (%q, %q, %q), single reply (%q, %q, %q)",
+			got.Text, got.SSML, got.SpeakText, single.Text, single.SSML, single.SpeakText)
+	}
+	if got.SpeakText != "Oh ****" {
+		t.Errorf("speakText = %q, want %q", got.SpeakText, "Oh ****")
+	}
+}
+
+func TestDetectIntentSSEFallback(t *testing.T) {
+	cfg := testDetectIntentConfig()
+	cfg.DefaultEmptyResponseText = "Sorry, I have no answer."
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{}})
+
+	lines := postDetectIntentSSE(t, `{"message":"hi","sessionId":"s1","stream":true,"fallbackText":"Lo siento."}`)
+	if len(lines) != 2 || lines[0].Text != "Lo siento." || !lines[0].WasFallback {
+		t.Errorf("lines = %+v, want the fallbackText as a wasFallback message", lines)
+	}
+}
+
+func TestStreamRejectsRaw(t *testing.T) {
+	cfg := testDetectIntentConfig()
+	cfg.Debug = true
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello"}}})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/stream-ndjson", strings.NewReader(`{"message":"hi","sessionId":"s1","raw":true}`))
+	rec := httptest.NewRecorder()
+	streamNDJSONHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestWriteResponsePartsMatchesSingleReply(t *testing.T) {
+	filter, err := newWordFilter([]string{"darn"}, "", filterModeBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testDetectIntentConfig()
+	cfg.ResponseFilter = filter
+	cfg.ResponseFilterFallbackText = "Blocked."
+	cfg.MultilangPrefixFormat = "[%s] "
+	cfg.StreamingResponseMinParts = 3
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{
+		"[EN] one", "[DE] eins", "[EN] two", "[EN] darn three", "[EN] <speak>four</speak>", "[EN] five",
+	}}})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/detectIntent", strings.NewReader(`{"message":"hi","sessionId":"s1","fallbackText":"Lo siento."}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", contentTypeNDJSON)
+	rec := httptest.NewRecorder()
+	detectIntentHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	want := []streamLine{
+		{Type: "message", Text: "one"},
+		{Type: "message", Text: "two"},
+		{Type: "message", Text: "Lo siento."},
+		{Type: "message", Text: "<speak>four</speak>", SSML: "<speak>four</speak>", SpeakText: "four"},
+		{Type: "message", Text: "five"},
+		{Type: "status", Status: "ok", SessionID: "s1"},
+	}
+	lines := decodeStreamLines(t, rec.Body.String())
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %+v, want %+v", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, lines[i], want[i])
+		}
+	}
+}
+
+// Records how many NDJSON lines had been written at each flush
+type flushCountingRecorder struct {
+	*httptest.ResponseRecorder
+	linesAtFlush []int
+}
+
+func (r *flushCountingRecorder) Flush() {
+	r.linesAtFlush = append(r.linesAtFlush, strings.Count(r.Body.String(), "\n"))
+	r.ResponseRecorder.Flush()
+}
+
+func TestWriteResponsePartsFlushesEachBubble(t *testing.T) {
+	cfg := testDetectIntentConfig()
+	cfg.StreamingResponseMinParts = 2
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"one", "two", "three"}}})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/dialogflow/detectIntent", strings.NewReader(`{"message":"hi","sessionId":"s1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", contentTypeNDJSON)
+	rec := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	detectIntentHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	// Three bubbles and the status line, each flushed on its own
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(rec.linesAtFlush, want) {
+		t.Errorf("lines written at each flush = %v, want %v", rec.linesAtFlush, want)
+	}
+}
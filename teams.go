@@ -0,0 +1,398 @@
+// teams.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bot Framework endpoints (TEAMS_OPENID_URL and TEAMS_TOKEN_URL override
+// them, e.g. for local stubs)
+const (
+	defaultTeamsOpenIDURL = "https://login.botframework.com/v1/.well-known/openidconfiguration"
+	defaultTeamsTokenURL  = "https://login.microsoftonline.com/botframework.com/oauth2/v2.0/token"
+	botFrameworkIssuer    = "https://api.botframework.com"
+	botFrameworkScope     = "https://api.botframework.com/.default"
+)
+
+// Allowed clock difference when checking token expiry
+const teamsClockSkew = 5 * time.Minute
+
+// Bot Framework Activity; only the fields used here
+type teamsActivity struct {
+	Type         string             `json:"type"`
+	ID           string             `json:"id,omitempty"`
+	Text         string             `json:"text,omitempty"`
+	ServiceURL   string             `json:"serviceUrl,omitempty"`
+	ChannelID    string             `json:"channelId,omitempty"`
+	From         *teamsAccount      `json:"from,omitempty"`
+	Recipient    *teamsAccount      `json:"recipient,omitempty"`
+	Conversation *teamsConversation `json:"conversation,omitempty"`
+	ReplyToID    string             `json:"replyToId,omitempty"`
+}
+
+type teamsAccount struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type teamsConversation struct {
+	ID string `json:"id"`
+}
+
+// Handles POST /webhook/teams: verifies the Bot Framework token, sends the
+// activity text to the default agent and posts the reply to the
+// conversation through the Bot Connector API
+func teamsWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var activity teamsActivity
+	if err := json.NewDecoder(r.Body).Decode(&activity); err != nil {
+		log.Printf("Error parsing Teams activity: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Invalid activity")
+		return
+	}
+	if err := verifyBotFrameworkToken(r.Context(), r.Header.Get("Authorization"), activity.ServiceURL, activity.ChannelID); err != nil {
+		log.Printf("Rejected Teams activity: %v", err)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Conversation updates, typing indicators etc. carry no text
+	if activity.Type != "message" || activity.Text == "" || activity.From == nil || activity.Conversation == nil {
+		log.Printf("Ignoring Teams activity of type %s", activity.Type)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	call, err := prepareDetectIntent(DetectIntentRequest{
+		Message:   activity.Text,
+		SessionID: teamsSessionID(activity.From.ID),
+	})
+	if err != nil {
+		log.Printf("Validation Error: %v", err)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if isDraining(call.agentID) {
+		log.Printf("Rejected Teams activity for draining agent %s", call.agentID)
+		writeRetryableError(w, r, http.StatusServiceUnavailable, "Agent is draining, retry shortly", drainRetryAfterSeconds)
+		return
+	}
+
+	ctx, cancel := dialogflowContext(w, r, call.agentID)
+	defer cancel()
+
+	response, err := detectIntentCore(ctx, http.Header{}, call)
+	if err != nil {
+		writeDetectIntentError(w, r, err)
+		return
+	}
+	if response.Text == "" {
+		log.Printf("No text to reply to Teams conversation %s", activity.Conversation.ID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	reply := teamsActivity{
+		Type:         "message",
+		Text:         response.displayText(),
+		From:         activity.Recipient,
+		Recipient:    activity.From,
+		Conversation: activity.Conversation,
+		ReplyToID:    activity.ID,
+	}
+	if err := sendTeamsReply(ctx, activity, reply); err != nil {
+		log.Printf("Error replying to Teams: %v", err)
+		writeError(w, r, http.StatusBadGateway, "Teams reply failed")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Teams user IDs are longer than the 36 characters CX allows for a session
+// ID, so sessions are keyed by a hash of the ID
+func teamsSessionID(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return "teams-" + hex.EncodeToString(sum[:])[:30]
+}
+
+// Posts the reply activity to the conversation it answers
+func sendTeamsReply(ctx context.Context, activity, reply teamsActivity) error {
+	token, err := teamsBotToken.get(ctx)
+	if err != nil {
+		return fmt.Errorf("getting bot token: %w", err)
+	}
+	body, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/v3/conversations/%s/activities/%s",
+		strings.TrimSuffix(activity.ServiceURL, "/"), url.PathEscape(activity.Conversation.ID), url.PathEscape(activity.ID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Bot Connector returned %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
+
+// --- Inbound token verification ---
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type botFrameworkClaims struct {
+	Issuer     string `json:"iss"`
+	Audience   string `json:"aud"`
+	Expiry     int64  `json:"exp"`
+	NotBefore  int64  `json:"nbf"`
+	ServiceURL string `json:"serviceurl"`
+}
+
+// Checks the "Bearer <JWT>" sent by the Bot Framework: an RS256 signature
+// by one of its published keys endorsed for the activity's channel, its
+// issuer, TEAMS_APP_ID as audience, the validity window, and that it was
+// issued for the activity's serviceUrl. The bot's own access token is later
+// sent to that serviceUrl, so a token without the claim is rejected.
+func verifyBotFrameworkToken(ctx context.Context, authorization, serviceURL, channelID string) error {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return errors.New("missing bearer token")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return fmt.Errorf("token header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	key, err := botFrameworkKeys.get(ctx, header.Kid)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(key.endorsements, channelID) {
+		return fmt.Errorf("signing key %q is not endorsed for channel %q", header.Kid, channelID)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key.key, crypto.SHA256, digest[:], signature); err != nil {
+		return errors.New("invalid token signature")
+	}
+
+	var claims botFrameworkClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return fmt.Errorf("token claims: %w", err)
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != botFrameworkIssuer:
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case claims.Audience != appConfig.TeamsAppID:
+		return fmt.Errorf("unexpected audience %q", claims.Audience)
+	case now.After(time.Unix(claims.Expiry, 0).Add(teamsClockSkew)):
+		return errors.New("token expired")
+	case claims.NotBefore != 0 && now.Add(teamsClockSkew).Before(time.Unix(claims.NotBefore, 0)):
+		return errors.New("token not valid yet")
+	case claims.ServiceURL == "":
+		return errors.New("token has no serviceurl claim")
+	case strings.TrimSuffix(claims.ServiceURL, "/") != strings.TrimSuffix(serviceURL, "/"):
+		return fmt.Errorf("token issued for service URL %q", claims.ServiceURL)
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Bot Framework signing keys, fetched through its OpenID configuration and
+// refreshed daily or when a token names an unknown key
+type botFrameworkKeySet struct {
+	mu        sync.Mutex
+	keys      map[string]botFrameworkKey
+	fetchedAt time.Time
+}
+
+// A signing key and the channels (e.g. "msteams") it may sign tokens for
+type botFrameworkKey struct {
+	key          *rsa.PublicKey
+	endorsements []string
+}
+
+var botFrameworkKeys = &botFrameworkKeySet{}
+
+// Shortest interval between refreshes triggered by unknown key IDs
+const minKeyRefreshInterval = 5 * time.Minute
+
+func (s *botFrameworkKeySet) get(ctx context.Context, kid string) (botFrameworkKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	age := time.Since(s.fetchedAt)
+	key, known := s.keys[kid]
+	if age > 24*time.Hour || (!known && age > minKeyRefreshInterval) {
+		keys, err := fetchBotFrameworkKeys(ctx)
+		if err != nil {
+			return botFrameworkKey{}, fmt.Errorf("fetching Bot Framework keys: %w", err)
+		}
+		s.keys, s.fetchedAt = keys, time.Now()
+		key, known = s.keys[kid]
+	}
+	if !known {
+		return botFrameworkKey{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+type jsonWebKey struct {
+	Kty          string   `json:"kty"`
+	Kid          string   `json:"kid"`
+	N            string   `json:"n"`
+	E            string   `json:"e"`
+	Endorsements []string `json:"endorsements"`
+}
+
+func fetchBotFrameworkKeys(ctx context.Context) (map[string]botFrameworkKey, error) {
+	var openIDConfig struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, appConfig.TeamsOpenIDURL, &openIDConfig); err != nil {
+		return nil, err
+	}
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, openIDConfig.JWKSURI, &keySet); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]botFrameworkKey{}
+	for _, jwk := range keySet.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			log.Printf("Warning: Skipping malformed Bot Framework key %q", jwk.Kid)
+			continue
+		}
+		keys[jwk.Kid] = botFrameworkKey{
+			key: &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			},
+			endorsements: jwk.Endorsements,
+		}
+	}
+	return keys, nil
+}
+
+func getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// --- Outbound bot token ---
+
+// Access token for the Bot Connector API, obtained with TEAMS_APP_ID and
+// TEAMS_APP_PASSWORD (client credentials) and reused until shortly before
+// it expires
+type botTokenCache struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+var teamsBotToken = &botTokenCache{}
+
+func (c *botTokenCache) get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {appConfig.TeamsAppID},
+		"client_secret": {appConfig.TeamsAppPassword},
+		"scope":         {botFrameworkScope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, appConfig.TeamsTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	c.token = token.AccessToken
+	c.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	testTeamsAppID      = "test-app-id"
+	testTeamsServiceURL = "https://smba.trafficmanager.net/emea/"
+)
+
+// Serves an OpenID configuration and JWKS publishing key as "key-1",
+// endorsed for msteams, and points the verifier at it
+func newBotFrameworkKeyServer(t *testing.T, key *rsa.PrivateKey) {
+	t.Helper()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/openid", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+			Kty:          "RSA",
+			Kid:          "key-1",
+			N:            base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:            base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			Endorsements: []string{"msteams"},
+		}}})
+	})
+
+	setTestConfig(t, config{TeamsAppID: testTeamsAppID, TeamsOpenIDURL: server.URL + "/openid"})
+	previous := botFrameworkKeys
+	botFrameworkKeys = &botFrameworkKeySet{}
+	t.Cleanup(func() { botFrameworkKeys = previous })
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(jwtHeader{Alg: "RS256", Kid: kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyBotFrameworkToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBotFrameworkKeyServer(t, key)
+
+	validClaims := func() map[string]any {
+		return map[string]any{
+			"iss":        botFrameworkIssuer,
+			"aud":        testTeamsAppID,
+			"exp":        time.Now().Add(time.Hour).Unix(),
+			"nbf":        time.Now().Add(-time.Minute).Unix(),
+			"serviceurl": testTeamsServiceURL,
+		}
+	}
+	with := func(name string, value any) map[string]any {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name      string
+		key       *rsa.PrivateKey
+		kid       string
+		claims    map[string]any
+		channelID string
+		wantErr   bool
+	}{
+		{name: "valid", claims: validClaims()},
+		{name: "expired", claims: with("exp", time.Now().Add(-time.Hour).Unix()), wantErr: true},
+		{name: "wrong issuer", claims: with("iss", "https://sts.windows.net/"), wantErr: true},
+		{name: "wrong audience", claims: with("aud", "another-app"), wantErr: true},
+		{name: "missing serviceurl", claims: with("serviceurl", nil), wantErr: true},
+		{name: "mismatched serviceurl", claims: with("serviceurl", "https://attacker.example/"), wantErr: true},
+		{name: "unknown kid", kid: "key-2", claims: validClaims(), wantErr: true},
+		{name: "bad signature", key: otherKey, claims: validClaims(), wantErr: true},
+		{name: "channel not endorsed", claims: validClaims(), channelID: "webchat", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signingKey, kid, channelID := key, "key-1", "msteams"
+			if tt.key != nil {
+				signingKey = tt.key
+			}
+			if tt.kid != "" {
+				kid = tt.kid
+			}
+			if tt.channelID != "" {
+				channelID = tt.channelID
+			}
+			token := signTestToken(t, signingKey, kid, tt.claims)
+			err := verifyBotFrameworkToken(context.Background(), token, testTeamsServiceURL, channelID)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("verifyBotFrameworkToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTeamsWebhookReplies(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBotFrameworkKeyServer(t, key)
+
+	// Stands in for both the token endpoint and the Bot Connector API
+	var replies []teamsActivity
+	var replyPaths []string
+	connector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(map[string]any{"access_token": "bot-token", "expires_in": 3600})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer bot-token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		var reply teamsActivity
+		if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+			t.Errorf("decoding reply: %v", err)
+		}
+		replies = append(replies, reply)
+		replyPaths = append(replyPaths, r.URL.Path)
+	}))
+	defer connector.Close()
+
+	cfg := testDetectIntentConfig()
+	cfg.TeamsAppID = appConfig.TeamsAppID
+	cfg.TeamsOpenIDURL = appConfig.TeamsOpenIDURL
+	cfg.TeamsTokenURL = connector.URL + "/token"
+	setTestConfig(t, cfg)
+	setTestSessionsClient(t, mockFixture{Default: &mockReply{Texts: []string{"hello from the agent"}}})
+	previousToken := teamsBotToken
+	teamsBotToken = &botTokenCache{}
+	t.Cleanup(func() { teamsBotToken = previousToken })
+
+	activity, _ := json.Marshal(teamsActivity{
+		Type:         "message",
+		ID:           "activity-1",
+		Text:         "hi",
+		ServiceURL:   connector.URL,
+		ChannelID:    "msteams",
+		From:         &teamsAccount{ID: "user-1"},
+		Recipient:    &teamsAccount{ID: "bot-1"},
+		Conversation: &teamsConversation{ID: "conversation-1"},
+	})
+	token := signTestToken(t, key, "key-1", map[string]any{
+		"iss":        botFrameworkIssuer,
+		"aud":        testTeamsAppID,
+		"exp":        time.Now().Add(time.Hour).Unix(),
+		"serviceurl": connector.URL,
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+		wantReplies   int
+	}{
+		{"signed", token, http.StatusOK, 1},
+		{"unsigned", "", http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies, replyPaths = nil, nil
+			req := httptest.NewRequest(http.MethodPost, "/webhook/teams", bytes.NewReader(activity))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			teamsWebhookHandler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.want, rec.Body.String())
+			}
+			if len(replies) != tt.wantReplies {
+				t.Fatalf("sent %d replies, want %d", len(replies), tt.wantReplies)
+			}
+			if tt.wantReplies == 0 {
+				return
+			}
+			if want := "/v3/conversations/conversation-1/activities/activity-1"; replyPaths[0] != want {
+				t.Errorf("reply posted to %s, want %s", replyPaths[0], want)
+			}
+			if reply := replies[0]; reply.Text != "hello from the agent" || reply.ReplyToID != "activity-1" || reply.Recipient.ID != "user-1" {
+				t.Errorf("reply = %+v", reply)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// testagent.go
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+)
+
+// Fixed session so smoke tests never create per-run sessions
+const testAgentSessionID = "health-check-session"
+
+type testAgentResponse struct {
+	OK            bool   `json:"ok"`
+	IntentMatched string `json:"intentMatched,omitempty"`
+	LatencyMs     int64  `json:"latencyMs"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Handles GET /api/dialogflow/test-agent: sends one message to the agent and
+// reports the matched intent and latency, or 503 if the call fails. Meant as
+// a post-deploy smoke test, so it is not tied to any client session.
+func testAgentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	query := r.URL.Query()
+	agentID := query.Get("agentId")
+	if agentID == "" {
+		agentID = appConfig.DefaultAgentID
+	}
+	if agentID == "" {
+		writeError(w, r, http.StatusBadRequest, appConfig.NoAgentErrorMessage)
+		return
+	}
+	message := query.Get("message")
+	if message == "" {
+		message = "hello"
+	}
+	langCode := query.Get("languageCode")
+	if langCode == "" {
+		langCode = "en"
+	}
+
+	sessionPath, err := buildSessionPath(appConfig.ProjectID, appConfig.LocationID, agentID, testAgentSessionID)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := dialogflowContext(w, r, agentID)
+	defer cancel()
+
+	start := time.Now()
+	queryResult, err := testAgentCall(ctx, sessionPath, message, langCode)
+	if err != nil {
+		log.Printf("Agent test against %s failed: %v", sessionPath, err)
+		writeResponse(w, r, http.StatusServiceUnavailable, testAgentResponse{Error: err.Error(), LatencyMs: time.Since(start).Milliseconds()})
+		return
+	}
+
+	intent := queryResult.GetMatch().GetIntent().GetDisplayName()
+	log.Printf("Agent test against %s matched intent %q", sessionPath, intent)
+	writeResponse(w, r, http.StatusOK, testAgentResponse{
+		OK:            true,
+		IntentMatched: intent,
+		LatencyMs:     time.Since(start).Milliseconds(),
+	})
+}
+
+// Sends a single DetectIntent call under the usual quota and concurrency
+// limits
+func testAgentCall(ctx context.Context, sessionPath, message, langCode string) (*cxpb.QueryResult, error) {
+	if err := waitDialogflowQuota(ctx); err != nil {
+		return nil, err
+	}
+	if err := acquireDialogflowCall(); err != nil {
+		return nil, err
+	}
+	response, err := sessionsClient.DetectIntent(ctx, &cxpb.DetectIntentRequest{
+		Session:    sessionPath,
+		QueryInput: toCXQueryInput(QueryInput{Message: message}, langCode),
+	})
+	releaseDialogflowCall()
+	countDetectIntentCall()
+	if err != nil {
+		return nil, err
+	}
+	if response.GetQueryResult() == nil {
+		return nil, errEmptyQueryResult
+	}
+	return response.GetQueryResult(), nil
+}
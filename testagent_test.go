@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/googleapis/gax-go/v2"
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type unavailableSessionsClient struct {
+	*mockSessionsClient
+}
+
+func (c unavailableSessionsClient) DetectIntent(ctx context.Context, req *cxpb.DetectIntentRequest, opts ...gax.CallOption) (*cxpb.DetectIntentResponse, error) {
+	return nil, status.Error(codes.Unavailable, "agent unreachable")
+}
+
+func getTestAgent(t *testing.T) (*httptest.ResponseRecorder, testAgentResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	testAgentHandler(rec, httptest.NewRequest(http.MethodGet, "/api/dialogflow/test-agent?message=hi", nil))
+	var response testAgentResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding reply %q: %v", rec.Body.String(), err)
+	}
+	return rec, response
+}
+
+func TestTestAgentHandler(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	setTestSessionsClient(t, mockFixture{Replies: []mockReply{{Message: "hi", Intent: "greeting", Texts: []string{"hello"}}}})
+
+	rec, response := getTestAgent(t)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !response.OK || response.IntentMatched != "greeting" || response.Error != "" {
+		t.Errorf("response = %+v, want ok with intent greeting", response)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+}
+
+func TestTestAgentHandlerReportsFailure(t *testing.T) {
+	setTestConfig(t, testDetectIntentConfig())
+	previous := sessionsClient
+	sessionsClient = unavailableSessionsClient{&mockSessionsClient{}}
+	t.Cleanup(func() { sessionsClient = previous })
+
+	rec, response := getTestAgent(t)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if response.OK || response.Error == "" {
+		t.Errorf("response = %+v, want not ok with an error", response)
+	}
+}
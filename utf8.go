@@ -0,0 +1,58 @@
+// utf8.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// UTF8_MODE values: reject invalid UTF-8 with a 400, or replace invalid
+// bytes with U+FFFD and carry on
+const (
+	utf8ModeReject   = "reject"
+	utf8ModeSanitize = "sanitize"
+)
+
+var errInvalidUTF8Body = errors.New("Request body is not valid UTF-8")
+
+// Checks a raw JSON body before decoding, which would otherwise silently
+// turn invalid bytes into U+FFFD. Returns the (possibly sanitized) body.
+func checkBodyUTF8(body []byte) ([]byte, error) {
+	if utf8.Valid(body) {
+		return body, nil
+	}
+	if appConfig.UTF8Mode == utf8ModeSanitize {
+		return bytes.ToValidUTF8(body, []byte("\uFFFD")), nil
+	}
+	return nil, errInvalidUTF8Body
+}
+
+// A request field checked for valid UTF-8
+type textField struct {
+	name  string
+	value *string
+}
+
+// Checks the text fields sent to Dialogflow CX, which rejects invalid UTF-8
+// with a confusing error. Sanitizes them in place in sanitize mode.
+func checkRequestUTF8(req *DetectIntentRequest) error {
+	fields := []textField{{"message", &req.Message}, {"fallbackText", &req.FallbackText}}
+	for i := range req.Inputs {
+		fields = append(fields,
+			textField{fmt.Sprintf("inputs[%d].message", i), &req.Inputs[i].Message},
+			textField{fmt.Sprintf("inputs[%d].event", i), &req.Inputs[i].Event})
+	}
+	for _, field := range fields {
+		if utf8.ValidString(*field.value) {
+			continue
+		}
+		if appConfig.UTF8Mode != utf8ModeSanitize {
+			return fmt.Errorf("%s is not valid UTF-8", field.name)
+		}
+		*field.value = strings.ToValidUTF8(*field.value, "\uFFFD")
+	}
+	return nil
+}
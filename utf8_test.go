@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+func TestCheckBodyUTF8(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"valid", utf8ModeReject, `{"message":"héllo"}`, `{"message":"héllo"}`, false},
+		{"reject invalid", utf8ModeReject, "{\"message\":\"h\xffllo\"}", "", true},
+		{"sanitize invalid", utf8ModeSanitize, "{\"message\":\"h\xffllo\"}", `{"message":"h` + "�" + `llo"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, config{UTF8Mode: tt.mode})
+			got, err := checkBodyUTF8([]byte(tt.body))
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("checkBodyUTF8() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("checkBodyUTF8() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRequestUTF8(t *testing.T) {
+	invalid := "h\xffllo"
+	tests := []struct {
+		name    string
+		mode    string
+		req     DetectIntentRequest
+		wantErr string
+		want    DetectIntentRequest
+	}{
+		{
+			name: "valid",
+			mode: utf8ModeReject,
+			req:  DetectIntentRequest{Message: "héllo"},
+			want: DetectIntentRequest{Message: "héllo"},
+		},
+		{
+			name:    "reject message",
+			mode:    utf8ModeReject,
+			req:     DetectIntentRequest{Message: invalid},
+			wantErr: "message is not valid UTF-8",
+		},
+		{
+			name:    "reject input event",
+			mode:    utf8ModeReject,
+			req:     DetectIntentRequest{Inputs: []QueryInput{{Message: "ok"}, {Event: invalid}}},
+			wantErr: "inputs[1].event is not valid UTF-8",
+		},
+		{
+			name: "sanitize",
+			mode: utf8ModeSanitize,
+			req:  DetectIntentRequest{FallbackText: invalid, Inputs: []QueryInput{{Message: invalid}}},
+			want: DetectIntentRequest{FallbackText: "h�llo", Inputs: []QueryInput{{Message: "h�llo"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, config{UTF8Mode: tt.mode})
+			req := tt.req
+			err := checkRequestUTF8(&req)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("checkRequestUTF8() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkRequestUTF8() error = %v", err)
+			}
+			if req.Message != tt.want.Message || req.FallbackText != tt.want.FallbackText {
+				t.Errorf("request = %+v, want %+v", req, tt.want)
+			}
+			for i := range tt.want.Inputs {
+				if req.Inputs[i] != tt.want.Inputs[i] {
+					t.Errorf("inputs[%d] = %+v, want %+v", i, req.Inputs[i], tt.want.Inputs[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// webhook.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	cxpb "google.golang.org/genproto/googleapis/cloud/dialogflow/cx/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Largest webhook request body accepted for forwarding
+const maxWebhookBodyBytes = 1 << 20
+
+// Client for forwarded webhook calls. CX waits at most 30 seconds for a
+// webhook, so upstreams slower than that are pointless to wait for.
+var webhookHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Response from one forwarding upstream
+type upstreamResponse struct {
+	status      int
+	contentType string
+	body        []byte
+}
+
+// Handles POST /webhook/forward (behind WEBHOOK_FORWARD_KEY): fans a
+// Dialogflow CX webhook call out to every URL in WEBHOOK_FORWARD_URLS
+// concurrently and answers CX with the response of the first (primary)
+// URL. Non-2xx responses from the other URLs are only logged.
+func webhookForwardHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		log.Printf("Error reading webhook request body: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	// The body is only checked to be a webhook request; upstreams get the
+	// original bytes so fields this proxy does not know about still reach them
+	var webhookRequest cxpb.WebhookRequest
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, &webhookRequest); err != nil {
+		log.Printf("Error parsing webhook request: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Invalid webhook request")
+		return
+	}
+
+	urls := appConfig.WebhookForwardURLs
+	responses := make([]*upstreamResponse, len(urls))
+	var g errgroup.Group
+	for i, url := range urls {
+		g.Go(func() error {
+			response, err := forwardWebhook(r.Context(), url, body)
+			if err != nil {
+				log.Printf("Warning: webhook forward to %s failed: %v", url, err)
+				if i == 0 {
+					return err
+				}
+				return nil
+			}
+			if response.status < 200 || response.status > 299 {
+				log.Printf("Warning: webhook forward to %s returned %d", url, response.status)
+			}
+			responses[i] = response
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		writeError(w, r, http.StatusBadGateway, "Primary webhook upstream unavailable")
+		return
+	}
+
+	log.Printf("Forwarded webhook (tag %q) to %d upstreams", webhookRequest.GetFulfillmentInfo().GetTag(), len(urls))
+	primary := responses[0]
+	if primary.contentType != "" {
+		w.Header().Set("Content-Type", primary.contentType)
+	}
+	w.WriteHeader(primary.status)
+	w.Write(primary.body)
+}
+
+// POSTs the raw webhook body to one upstream and reads its response
+func forwardWebhook(ctx context.Context, url string, body []byte) (*upstreamResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	// Only reached for calls that passed WEBHOOK_FORWARD_KEY, so the proxy
+	// never signs bodies from unauthenticated callers
+	if appConfig.OutboundHMACKey != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Picolo-Timestamp", timestamp)
+		req.Header.Set("X-Picolo-Signature", outboundSignature(body, timestamp, appConfig.OutboundHMACKey))
+	}
+
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(io.LimitReader(resp.Body, maxWebhookBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	return &upstreamResponse{
+		status:      resp.StatusCode,
+		contentType: resp.Header.Get("Content-Type"),
+		body:        responseBody,
+	}, nil
+}
+
+// Hex-encoded HMAC-SHA256 of "<timestamp>.<body>", so upstreams can verify
+// a forwarded call came from this proxy unmodified and reject stale or
+// replayed calls by their X-Picolo-Timestamp
+func outboundSignature(body []byte, timestamp, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
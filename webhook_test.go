@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Records the body of each call and answers with a fixed status
+func newUpstream(t *testing.T, status int, bodies chan<- string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(status)
+		io.WriteString(w, `{"fulfillmentResponse":{}}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebhookForwardRequiresKey(t *testing.T) {
+	bodies := make(chan string, 1)
+	upstream := newUpstream(t, http.StatusOK, bodies)
+	setTestConfig(t, config{WebhookForwardURLs: []string{upstream.URL}, WebhookForwardKey: "agent-key"})
+	handler := AuthMiddleware(appConfig.WebhookForwardKey)(http.HandlerFunc(webhookForwardHandler))
+
+	for _, authorization := range []string{"", "Bearer wrong-key"} {
+		req := httptest.NewRequest(http.MethodPost, "/webhook/forward", strings.NewReader(`{"fulfillmentInfo":{"tag":"t"}}`))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", authorization, rec.Code)
+		}
+	}
+	if len(bodies) != 0 {
+		t.Errorf("upstream was called %d times for unauthenticated requests", len(bodies))
+	}
+}
+
+func TestWebhookForwardSendsOriginalBodyToAllUpstreams(t *testing.T) {
+	primaryBodies := make(chan string, 1)
+	secondaryBodies := make(chan string, 1)
+	primary := newUpstream(t, http.StatusOK, primaryBodies)
+	secondary := newUpstream(t, http.StatusInternalServerError, secondaryBodies)
+	setTestConfig(t, config{WebhookForwardURLs: []string{primary.URL, secondary.URL}, WebhookForwardKey: "agent-key"})
+	handler := AuthMiddleware(appConfig.WebhookForwardKey)(http.HandlerFunc(webhookForwardHandler))
+
+	// newerField stands in for a field added to CX after this proxy was built
+	const sent = `{"fulfillmentInfo":{"tag":"order"},"newerField":{"value":1}}`
+	req := httptest.NewRequest(http.MethodPost, "/webhook/forward", strings.NewReader(sent))
+	req.Header.Set("Authorization", "Bearer agent-key")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want the primary's 200", rec.Code)
+	}
+	for name, bodies := range map[string]chan string{"primary": primaryBodies, "secondary": secondaryBodies} {
+		select {
+		case body := <-bodies:
+			if body != sent {
+				t.Errorf("%s upstream got %s, want the original body %s", name, body, sent)
+			}
+		default:
+			t.Errorf("%s upstream was not called", name)
+		}
+	}
+}
+
+func TestOutboundSignature(t *testing.T) {
+	// HMAC-SHA256("test-key", `1700000000.{"detectIntentResponseId":"abc"}`)
+	const want = "0fee7e5d7a2f0464e053ce82fedc4763b966dbf15456a45d35b23a24332b6756"
+	got := outboundSignature([]byte(`{"detectIntentResponseId":"abc"}`), "1700000000", "test-key")
+	if got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if other := outboundSignature([]byte(`{"detectIntentResponseId":"abc"}`), "1700000001", "test-key"); other == want {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
+
+func TestWebhookForwardSignsCalls(t *testing.T) {
+	type signedCall struct{ body, timestamp, signature string }
+	calls := make(chan signedCall, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls <- signedCall{string(body), r.Header.Get("X-Picolo-Timestamp"), r.Header.Get("X-Picolo-Signature")}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	setTestConfig(t, config{WebhookForwardURLs: []string{upstream.URL}, WebhookForwardKey: "agent-key", OutboundHMACKey: "test-key"})
+
+	before := time.Now().Unix()
+	req := httptest.NewRequest(http.MethodPost, "/webhook/forward", strings.NewReader(`{"fulfillmentInfo":{"tag":"t"}}`))
+	req.Header.Set("Authorization", "Bearer agent-key")
+	rec := httptest.NewRecorder()
+	AuthMiddleware(appConfig.WebhookForwardKey)(http.HandlerFunc(webhookForwardHandler)).ServeHTTP(rec, req)
+
+	call := <-calls
+	timestamp, err := strconv.ParseInt(call.timestamp, 10, 64)
+	if err != nil || timestamp < before || timestamp > time.Now().Unix() {
+		t.Fatalf("X-Picolo-Timestamp = %q, want the current Unix time", call.timestamp)
+	}
+	if want := outboundSignature([]byte(call.body), call.timestamp, "test-key"); call.signature != want {
+		t.Errorf("X-Picolo-Signature = %q, want %q", call.signature, want)
+	}
+}